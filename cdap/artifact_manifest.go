@@ -0,0 +1,209 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdap
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Matches JAR file names that follow the name-version.jar convention, e.g. my-plugin-1.2.0-SNAPSHOT.jar,
+// in the form [matched string, name, version]. GCS paths may end in the generation of the object.
+var jarFileNameRE = regexp.MustCompile(`^(.+?)-(\d+(?:\.\d+)*(?:[.-].+?)?)\.jar(?:#\d+)?$`)
+
+// openJarFunc opens the JAR at path for random access, so that its manifest can be read without
+// reading the whole JAR.
+type openJarFunc func(ctx context.Context, config *Config, path string) (*io.SectionReader, io.Closer, error)
+
+func openLocalJar(_ context.Context, _ *Config, path string) (*io.SectionReader, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return io.NewSectionReader(f, 0, info.Size()), f, nil
+}
+
+// artifactIdentity is the name and version of an artifact as declared by its JAR.
+type artifactIdentity struct {
+	name    string
+	version string
+	// manifestVersion is the Bundle-Version of the manifest, which CDAP requires the version to match.
+	manifestVersion string
+}
+
+// readArtifactIdentity returns the name and version of the JAR at jarPath from the
+// Bundle-SymbolicName and Bundle-Version of its manifest, or else from its file name.
+func readArtifactIdentity(ctx context.Context, config *Config, open openJarFunc, jarPath string) (*artifactIdentity, error) {
+	r, closer, err := open(ctx, config, jarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	manifest, err := jarManifest(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest of %v: %v", jarPath, err)
+	}
+
+	id := new(artifactIdentity)
+	if m := jarFileNameRE.FindStringSubmatch(path.Base(jarPath)); m != nil {
+		id.name, id.version = m[1], m[2]
+	}
+	// Directives such as singleton:=true follow the symbolic name.
+	if name := strings.TrimSpace(strings.Split(manifest["Bundle-SymbolicName"], ";")[0]); name != "" {
+		id.name = name
+	}
+	if v := manifest["Bundle-Version"]; v != "" {
+		id.version = v
+		id.manifestVersion = v
+	}
+	return id, nil
+}
+
+// jarManifest returns the main attributes of the manifest of the JAR, which are empty if it has none.
+func jarManifest(r *io.SectionReader) (map[string]string, error) {
+	zr, err := zip.NewReader(r, r.Size())
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if !strings.EqualFold(f.Name, "META-INF/MANIFEST.MF") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		b, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return nil, err
+		}
+		return parseManifest(string(b)), nil
+	}
+	return map[string]string{}, nil
+}
+
+// parseManifest parses the main section of a JAR manifest, whose long values are continued on
+// lines starting with a space.
+func parseManifest(s string) map[string]string {
+	attrs := make(map[string]string)
+	last := ""
+	for _, line := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, " ") {
+			if last != "" {
+				attrs[last] += line[1:]
+			}
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		last = strings.TrimSpace(k)
+		attrs[last] = strings.TrimSpace(v)
+	}
+	return attrs
+}
+
+// customizeDiffArtifactIdentity plans the name and version of an artifact from its JAR if they are
+// not set, and fails the plan if the version does not match the manifest. The JAR is only read
+// when the artifact is created or the JAR changed.
+func customizeDiffArtifactIdentity(open openJarFunc, jarHashKey string) schema.CustomizeDiffFunc {
+	return func(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+		if d.Id() != "" && !d.HasChange(jarHashKey) && !d.HasChange("name") && !d.HasChange("version") {
+			return nil
+		}
+		raw := d.GetRawConfig()
+		nameSet := !raw.GetAttr("name").IsNull()
+		versionSet := !raw.GetAttr("version").IsNull()
+
+		var id *artifactIdentity
+		var err error
+		if d.NewValueKnown("jar_binary_path") {
+			id, err = readArtifactIdentity(ctx, m.(*Config), open, d.Get("jar_binary_path").(string))
+		}
+		if id == nil || err != nil {
+			// The JAR may be created during apply, in which case it is read on create.
+			if err != nil && d.Id() != "" {
+				return err
+			}
+			for k, set := range map[string]bool{"name": nameSet, "version": versionSet} {
+				if !set {
+					if err := d.SetNewComputed(k); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+
+		if versionSet && d.NewValueKnown("version") && id.manifestVersion != "" && d.Get("version").(string) != id.manifestVersion {
+			return fmt.Errorf("version %q does not match Bundle-Version %q of the manifest of %v", d.Get("version"), id.manifestVersion, d.Get("jar_binary_path"))
+		}
+		for k, v := range map[string]string{"name": id.name, "version": id.version} {
+			if k == "name" && nameSet || k == "version" && versionSet {
+				continue
+			}
+			if v == "" {
+				return fmt.Errorf("%v is not set and could not be inferred from the manifest or file name of %v", k, d.Get("jar_binary_path"))
+			}
+			if d.Get(k).(string) != v {
+				if err := d.SetNew(k, v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// resolveArtifactIdentity sets the name and version of an artifact from its JAR if they could not
+// be inferred during plan.
+func resolveArtifactIdentity(ctx context.Context, config *Config, d *schema.ResourceData, open openJarFunc) error {
+	if d.Get("name").(string) != "" && d.Get("version").(string) != "" {
+		return nil
+	}
+	id, err := readArtifactIdentity(ctx, config, open, d.Get("jar_binary_path").(string))
+	if err != nil {
+		return err
+	}
+	for k, v := range map[string]string{"name": id.name, "version": id.version} {
+		if d.Get(k).(string) != "" {
+			continue
+		}
+		if v == "" {
+			return fmt.Errorf("%v is not set and could not be inferred from the manifest or file name of %v", k, d.Get("jar_binary_path"))
+		}
+		d.Set(k, v)
+	}
+	return nil
+}
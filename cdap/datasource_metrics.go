@@ -0,0 +1,280 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdap
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// dataSourceMetrics queries the metrics of CDAP, e.g. the records processed by the runs of a pipeline.
+// https://docs.cdap.io/cdap/current/en/reference-manual/http-restful-api/metrics.html
+func dataSourceMetrics() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceMetricsRead,
+		Schema: map[string]*schema.Schema{
+			"tags": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Description: "The tags to query the metrics of, e.g. namespace, app, workflow or run.",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"metrics": {
+				Type:        schema.TypeList,
+				Required:    true,
+				MinItems:    1,
+				Description: "The names of the metrics to query. Names can contain * wildcards, e.g. user.*.records.out, which are expanded to the metrics available for the tags.",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"group_by": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "The tags to group the series by, e.g. run.",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"start": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The start of the time range, in seconds since epoch or relative to now, e.g. now-1h.",
+			},
+			"end": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The end of the time range, in seconds since epoch or relative to now, e.g. now.",
+			},
+			"aggregate": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Whether to return a single aggregated value per series instead of a time series.",
+			},
+			"resolution": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "auto",
+				Description:  "The resolution of the time series. One of 1s, 1m, 1h, or auto.",
+				ValidateFunc: validation.StringInSlice([]string{"1s", "1m", "1h", "auto"}, false),
+			},
+			"series": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "The series returned by the query.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"metric": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The name of the metric.",
+						},
+						"grouping": {
+							Type:        schema.TypeMap,
+							Computed:    true,
+							Description: "The values of the group_by tags of the series.",
+							Elem:        &schema.Schema{Type: schema.TypeString},
+						},
+						"total": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "The sum of the values of the data points of the series, e.g. the records processed in the time range. It is only meaningful for counters, as the sum of the values of a gauge such as a count of running programs depends on the resolution; use point for gauges.",
+						},
+						"point": {
+							Type:        schema.TypeList,
+							Computed:    true,
+							Description: "The data points of the series.",
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"time": {
+										Type:        schema.TypeInt,
+										Computed:    true,
+										Description: "The time of the data point, in seconds since epoch.",
+									},
+									"value": {
+										Type:        schema.TypeInt,
+										Computed:    true,
+										Description: "The value of the data point.",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+type metricsQueryResult struct {
+	Series []*metricsSeries `json:"series"`
+}
+
+type metricsSeries struct {
+	MetricName string            `json:"metricName"`
+	Grouping   map[string]string `json:"grouping"`
+	Data       []*metricsPoint   `json:"data"`
+}
+
+type metricsPoint struct {
+	Time  int64 `json:"time"`
+	Value int64 `json:"value"`
+}
+
+func dataSourceMetricsRead(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+
+	tags := toStringMap(d.Get("tags").(map[string]interface{}))
+	var metrics []string
+	for _, v := range d.Get("metrics").([]interface{}) {
+		metrics = append(metrics, v.(string))
+	}
+	q := metricsTagQuery(tags)
+	for _, v := range d.Get("group_by").([]interface{}) {
+		q.Add("groupBy", v.(string))
+	}
+	for _, k := range []string{"start", "end", "resolution"} {
+		if v := d.Get(k).(string); v != "" {
+			q.Set(k, v)
+		}
+	}
+	q.Set("aggregate", strconv.FormatBool(d.Get("aggregate").(bool)))
+
+	// The id is derived from the inputs rather than the query, as the available metrics and thus the
+	// expanded names may change between reads.
+	id := url.Values{"metric": metrics}
+	for k, v := range q {
+		id[k] = v
+	}
+
+	metrics, err := expandMetricNames(config, tags, metrics)
+	if err != nil {
+		return err
+	}
+	for _, name := range metrics {
+		q.Add("metric", name)
+	}
+
+	var series []interface{}
+	// The query fails if no metric is given, which is the case if no metric matched the wildcards.
+	if len(metrics) > 0 {
+		req, err := http.NewRequest(http.MethodPost, urlJoin(config.host, "/v3/metrics/query")+"?"+q.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		b, err := httpCall(config, req)
+		if err != nil {
+			return fmt.Errorf("failed to query metrics: %v", err)
+		}
+		var res metricsQueryResult
+		if err := json.Unmarshal(b, &res); err != nil {
+			return fmt.Errorf("could not unmarshal metrics: %v", err)
+		}
+		for _, s := range res.Series {
+			series = append(series, flattenMetricsSeries(s))
+		}
+	}
+	if err := d.Set("series", series); err != nil {
+		return err
+	}
+
+	d.SetId(strconv.Itoa(schema.HashString(id.Encode())))
+	return nil
+}
+
+func metricsTagQuery(tags map[string]string) url.Values {
+	q := url.Values{}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		q.Add("tag", k+":"+tags[k])
+	}
+	return q
+}
+
+// expandMetricNames replaces the names with wildcards by the matching metrics available for the tags.
+func expandMetricNames(config *Config, tags map[string]string, names []string) ([]string, error) {
+	var available []string
+	var res []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !strings.Contains(name, "*") {
+			if !seen[name] {
+				seen[name] = true
+				res = append(res, name)
+			}
+			continue
+		}
+
+		if available == nil {
+			var err error
+			if available, err = searchMetricNames(config, tags); err != nil {
+				return nil, err
+			}
+		}
+		for _, a := range available {
+			// Metric names do not contain slashes, so * matches any part of the name, including dots.
+			if ok, err := path.Match(name, a); err != nil {
+				return nil, fmt.Errorf("invalid metric name %q: %v", name, err)
+			} else if ok && !seen[a] {
+				seen[a] = true
+				res = append(res, a)
+			}
+		}
+	}
+	return res, nil
+}
+
+func searchMetricNames(config *Config, tags map[string]string) ([]string, error) {
+	q := metricsTagQuery(tags)
+	q.Set("target", "metric")
+	req, err := http.NewRequest(http.MethodPost, urlJoin(config.host, "/v3/metrics/search")+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	b, err := httpCall(config, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search metrics: %v", err)
+	}
+	names := []string{}
+	if err := json.Unmarshal(b, &names); err != nil {
+		return nil, fmt.Errorf("could not unmarshal metric names: %v", err)
+	}
+	return names, nil
+}
+
+func flattenMetricsSeries(s *metricsSeries) map[string]interface{} {
+	var total int64
+	var points []interface{}
+	for _, p := range s.Data {
+		total += p.Value
+		points = append(points, map[string]interface{}{"time": p.Time, "value": p.Value})
+	}
+	return map[string]interface{}{
+		"metric":   s.MetricName,
+		"grouping": s.Grouping,
+		"total":    total,
+		"point":    points,
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdap
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// fakeMetricsServer serves the metrics search and query APIs and records the queries it received.
+type fakeMetricsServer struct {
+	available []string
+	result    *metricsQueryResult
+
+	searches []url.Values
+	queries  []url.Values
+}
+
+func (f *fakeMetricsServer) start(t *testing.T) *Config {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("got method %v for %v, want POST", r.Method, r.URL.Path)
+		}
+		switch r.URL.Path {
+		case "/v3/metrics/search":
+			f.searches = append(f.searches, r.URL.Query())
+			json.NewEncoder(w).Encode(f.available)
+		case "/v3/metrics/query":
+			f.queries = append(f.queries, r.URL.Query())
+			json.NewEncoder(w).Encode(f.result)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return &Config{host: srv.URL, httpClient: srv.Client()}
+}
+
+func readMetrics(t *testing.T, config *Config, raw map[string]interface{}) *schema.ResourceData {
+	t.Helper()
+	d := schema.TestResourceDataRaw(t, dataSourceMetrics().Schema, raw)
+	if err := dataSourceMetricsRead(d, config); err != nil {
+		t.Fatalf("dataSourceMetricsRead() failed: %v", err)
+	}
+	return d
+}
+
+func TestDataSourceMetricsReadQuery(t *testing.T) {
+	f := &fakeMetricsServer{
+		available: []string{"user.a.records.out", "user.b.records.out", "user.a.records.in", "system.records.out"},
+		result:    &metricsQueryResult{},
+	}
+	config := f.start(t)
+
+	d := readMetrics(t, config, map[string]interface{}{
+		"tags":      map[string]interface{}{"namespace": "default", "app": "p"},
+		"metrics":   []interface{}{"user.*.records.out", "system.records.out", "user.a.records.out"},
+		"group_by":  []interface{}{"run"},
+		"start":     "now-1h",
+		"end":       "now",
+		"aggregate": true,
+	})
+
+	wantTags := []string{"app:p", "namespace:default"}
+	if len(f.searches) != 1 {
+		t.Fatalf("got %d searches, want 1", len(f.searches))
+	}
+	if got := f.searches[0]["tag"]; !reflect.DeepEqual(got, wantTags) {
+		t.Errorf("got search tags %v, want %v", got, wantTags)
+	}
+	if got := f.searches[0].Get("target"); got != "metric" {
+		t.Errorf("got search target %q, want metric", got)
+	}
+
+	if len(f.queries) != 1 {
+		t.Fatalf("got %d queries, want 1", len(f.queries))
+	}
+	want := url.Values{
+		"tag":        wantTags,
+		"metric":     {"user.a.records.out", "user.b.records.out", "system.records.out"},
+		"groupBy":    {"run"},
+		"start":      {"now-1h"},
+		"end":        {"now"},
+		"resolution": {"auto"},
+		"aggregate":  {"true"},
+	}
+	if got := f.queries[0]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got query %v, want %v", got, want)
+	}
+
+	// The id only depends on the inputs, not on the metrics that are available.
+	id := d.Id()
+	f.available = append(f.available, "user.c.records.out")
+	d = readMetrics(t, config, map[string]interface{}{
+		"tags":      map[string]interface{}{"namespace": "default", "app": "p"},
+		"metrics":   []interface{}{"user.*.records.out", "system.records.out", "user.a.records.out"},
+		"group_by":  []interface{}{"run"},
+		"start":     "now-1h",
+		"end":       "now",
+		"aggregate": true,
+	})
+	if d.Id() != id {
+		t.Errorf("got id %q, want %q", d.Id(), id)
+	}
+}
+
+func TestDataSourceMetricsReadNoWildcard(t *testing.T) {
+	f := &fakeMetricsServer{result: &metricsQueryResult{}}
+	config := f.start(t)
+
+	readMetrics(t, config, map[string]interface{}{
+		"metrics": []interface{}{"system.process.events.processed"},
+	})
+	if len(f.searches) != 0 {
+		t.Errorf("got %d searches, want none", len(f.searches))
+	}
+	if len(f.queries) != 1 {
+		t.Fatalf("got %d queries, want 1", len(f.queries))
+	}
+	want := url.Values{
+		"metric":     {"system.process.events.processed"},
+		"resolution": {"auto"},
+		"aggregate":  {"false"},
+	}
+	if got := f.queries[0]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got query %v, want %v", got, want)
+	}
+}
+
+func TestDataSourceMetricsReadNoMatch(t *testing.T) {
+	f := &fakeMetricsServer{available: []string{"system.records.out"}}
+	config := f.start(t)
+
+	d := readMetrics(t, config, map[string]interface{}{
+		"tags":    map[string]interface{}{"namespace": "default"},
+		"metrics": []interface{}{"user.*"},
+	})
+	if len(f.searches) != 1 {
+		t.Errorf("got %d searches, want 1", len(f.searches))
+	}
+	if len(f.queries) != 0 {
+		t.Errorf("got %d queries, want none as no metric matched", len(f.queries))
+	}
+	if got := d.Get("series").([]interface{}); len(got) != 0 {
+		t.Errorf("got series %v, want none", got)
+	}
+	if d.Id() == "" {
+		t.Error("got empty id")
+	}
+}
+
+func TestDataSourceMetricsReadSeries(t *testing.T) {
+	f := &fakeMetricsServer{
+		result: &metricsQueryResult{Series: []*metricsSeries{
+			{
+				MetricName: "user.records.out",
+				Grouping:   map[string]string{"run": "r1"},
+				Data:       []*metricsPoint{{Time: 100, Value: 3}, {Time: 160, Value: 4}},
+			},
+			{
+				MetricName: "user.records.out",
+				Grouping:   map[string]string{"run": "r2"},
+			},
+		}},
+	}
+	config := f.start(t)
+
+	d := readMetrics(t, config, map[string]interface{}{
+		"metrics":  []interface{}{"user.records.out"},
+		"group_by": []interface{}{"run"},
+	})
+	want := []interface{}{
+		map[string]interface{}{
+			"metric":   "user.records.out",
+			"grouping": map[string]interface{}{"run": "r1"},
+			"total":    7,
+			"point": []interface{}{
+				map[string]interface{}{"time": 100, "value": 3},
+				map[string]interface{}{"time": 160, "value": 4},
+			},
+		},
+		map[string]interface{}{
+			"metric":   "user.records.out",
+			"grouping": map[string]interface{}{"run": "r2"},
+			"total":    0,
+			"point":    []interface{}{},
+		},
+	}
+	if got := d.Get("series"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got series %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdap
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// The run statuses accepted by the status filter of the runs API.
+var runStatusFilters = []string{"pending", "starting", "running", "suspended", "stopping", "completed", "killed", "failed", "rejected", "all"}
+
+// dataSourceProgramRuns lists the runs of a program, e.g. to check the outcome of recent runs.
+// https://docs.cdap.io/cdap/current/en/reference-manual/http-restful-api/lifecycle.html
+func dataSourceProgramRuns() *schema.Resource {
+	s := programDataSourceSchema()
+	s["status"] = &schema.Schema{
+		Type:         schema.TypeString,
+		Optional:     true,
+		Description:  "Only list runs with this status, e.g. failed. If not provided, runs with any status are listed.",
+		ValidateFunc: validation.StringInSlice(runStatusFilters, false),
+	}
+	s["start_time"] = &schema.Schema{
+		Type:         schema.TypeString,
+		Optional:     true,
+		Description:  "Only list runs that started at or after this time, in RFC3339 format.",
+		ValidateFunc: validation.IsRFC3339Time,
+	}
+	s["end_time"] = &schema.Schema{
+		Type:         schema.TypeString,
+		Optional:     true,
+		Description:  "Only list runs that started before this time, in RFC3339 format.",
+		ValidateFunc: validation.IsRFC3339Time,
+	}
+	s["limit"] = &schema.Schema{
+		Type:         schema.TypeInt,
+		Optional:     true,
+		Default:      25,
+		Description:  "The maximum number of runs to list, most recent first.",
+		ValidateFunc: validation.IntAtLeast(1),
+	}
+	s["runs"] = &schema.Schema{
+		Type:        schema.TypeList,
+		Computed:    true,
+		Description: "The runs of the program.",
+		Elem:        &schema.Resource{Schema: runDataSourceSchema()},
+	}
+
+	return &schema.Resource{
+		Read:   dataSourceProgramRunsRead,
+		Schema: s,
+	}
+}
+
+func dataSourceProgramRunsRead(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+
+	f := &runFilter{status: d.Get("status").(string), limit: d.Get("limit").(int)}
+	for k, bound := range map[string]*int64{"start_time": &f.start, "end_time": &f.end} {
+		if v := d.Get(k).(string); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return fmt.Errorf("invalid %v: %v", k, err)
+			}
+			*bound = t.Unix()
+		}
+	}
+
+	runs, err := listRuns(config, urlJoin(getProgramAddr(config, d), "runs"), f)
+	if err != nil {
+		return fmt.Errorf("failed to list runs: %v", err)
+	}
+	var res []interface{}
+	for _, r := range runs {
+		res = append(res, flattenRun(r))
+	}
+	if err := d.Set("runs", res); err != nil {
+		return err
+	}
+
+	d.SetId(strings.Join([]string{programResourceID(d), f.status, d.Get("start_time").(string), d.Get("end_time").(string)}, "/"))
+	return nil
+}
+
+// programDataSourceSchema returns the attributes identifying a program for data sources.
+func programDataSourceSchema() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		"namespace": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "The name of the namespace of the program. If not provided, the default namespace is used.",
+			DefaultFunc: func() (interface{}, error) {
+				return defaultNamespace, nil
+			},
+		},
+		"app": {
+			Type:        schema.TypeString,
+			Required:    true,
+			Description: "Name of the application.",
+		},
+		"program": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Default:     "DataPipelineWorkflow",
+			Description: "Name of the program.",
+		},
+		"type": {
+			Type:         schema.TypeString,
+			Optional:     true,
+			Default:      "workflows",
+			Description:  "One of mapreduce, services, spark, workers, or workflows.",
+			ValidateFunc: validation.StringInSlice([]string{"mapreduce", "services", "spark", "workers", "workflows"}, false),
+		},
+	}
+}
+
+// runDataSourceSchema returns the attributes of a run record.
+func runDataSourceSchema() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		"run_id": {
+			Type:        schema.TypeString,
+			Computed:    true,
+			Description: "The CDAP run ID.",
+		},
+		"status": {
+			Type:        schema.TypeString,
+			Computed:    true,
+			Description: "The status of the run.",
+		},
+		"start_time": {
+			Type:        schema.TypeString,
+			Computed:    true,
+			Description: "The time the run started, in RFC3339 format.",
+		},
+		"stop_time": {
+			Type:        schema.TypeString,
+			Computed:    true,
+			Description: "The time the run was requested to stop, in RFC3339 format.",
+		},
+		"end_time": {
+			Type:        schema.TypeString,
+			Computed:    true,
+			Description: "The time the run ended, in RFC3339 format.",
+		},
+		"cluster_status": {
+			Type:        schema.TypeString,
+			Computed:    true,
+			Description: "The status of the cluster the run executed on.",
+		},
+		"cluster_nodes": {
+			Type:        schema.TypeInt,
+			Computed:    true,
+			Description: "The number of nodes of the cluster the run executed on.",
+		},
+		"profile": {
+			Type:        schema.TypeString,
+			Computed:    true,
+			Description: "The compute profile of the run, prefixed with its scope, e.g. SYSTEM:dataproc.",
+		},
+	}
+}
+
+func flattenRun(r *run) map[string]interface{} {
+	res := map[string]interface{}{
+		"run_id":     r.RunID,
+		"status":     r.Status,
+		"start_time": formatRunTime(r.Start),
+		"stop_time":  formatRunTime(r.Stopping),
+		"end_time":   formatRunTime(r.End),
+	}
+	if r.Cluster != nil {
+		res["cluster_status"] = r.Cluster.Status
+		res["cluster_nodes"] = r.Cluster.NumNodes
+	}
+	if r.Profile != nil {
+		scope := "USER"
+		if r.Profile.Namespace == "system" {
+			scope = "SYSTEM"
+		}
+		res["profile"] = scope + ":" + r.Profile.Profile
+	}
+	return res
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdap
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// dataSourceProgramStatus exposes the current status and the latest run of a program, e.g. to
+// check the health of a pipeline after an apply.
+// https://docs.cdap.io/cdap/current/en/reference-manual/http-restful-api/lifecycle.html
+func dataSourceProgramStatus() *schema.Resource {
+	s := programDataSourceSchema()
+	s["status"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "The status of the program, e.g. RUNNING or STOPPED.",
+	}
+	s["latest_run"] = &schema.Schema{
+		Type:        schema.TypeList,
+		Computed:    true,
+		Description: "The most recent run of the program, if it has ever run.",
+		Elem:        &schema.Resource{Schema: runDataSourceSchema()},
+	}
+
+	return &schema.Resource{
+		Read:   dataSourceProgramStatusRead,
+		Schema: s,
+	}
+}
+
+func dataSourceProgramStatusRead(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	addr := getProgramAddr(config, d)
+
+	status, err := getProgramStatus(config, addr)
+	if err != nil {
+		return err
+	}
+	d.Set("status", status)
+
+	runs, err := listRuns(config, urlJoin(addr, "runs"), &runFilter{limit: 1})
+	if err != nil {
+		return fmt.Errorf("failed to get latest run: %v", err)
+	}
+	var latest []interface{}
+	if len(runs) > 0 {
+		latest = append(latest, flattenRun(runs[0]))
+	}
+	if err := d.Set("latest_run", latest); err != nil {
+		return err
+	}
+
+	d.SetId(programResourceID(d))
+	return nil
+}
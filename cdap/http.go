@@ -15,6 +15,7 @@
 package cdap
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -32,6 +33,11 @@ func (e *httpError) Error() string {
 	return fmt.Sprintf("%v: %v", e.code, e.body)
 }
 
+func isNotFound(err error) bool {
+	var herr *httpError
+	return errors.As(err, &herr) && herr.code == http.StatusNotFound
+}
+
 func urlJoin(base string, paths ...string) string {
 	p := path.Join(paths...)
 	return fmt.Sprintf("%s/%s", strings.TrimRight(base, "/"), strings.TrimLeft(p, "/"))
@@ -0,0 +1,239 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Macro functions such as ${secure(key)} or ${logicalStartTime(yyyy-MM-dd)} are evaluated by CDAP
+// rather than looked up in the runtime arguments.
+var macroFunctionRE = regexp.MustCompile(`(?s)^[A-Za-z_][A-Za-z0-9_]*\(.*\)$`)
+
+// specMacros returns the sorted, de-duplicated macros referenced by the string values of a JSON spec.
+func specMacros(spec string) ([]string, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(spec), &v); err != nil {
+		return nil, fmt.Errorf("failed to parse spec: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch t := v.(type) {
+		case string:
+			for _, m := range parseMacros(t) {
+				seen[m] = true
+			}
+		case []interface{}:
+			for _, e := range t {
+				walk(e)
+			}
+		case map[string]interface{}:
+			for _, e := range t {
+				walk(e)
+			}
+		}
+	}
+	walk(v)
+
+	var macros []string
+	for m := range seen {
+		macros = append(macros, m)
+	}
+	sort.Strings(macros)
+	return macros, nil
+}
+
+// parseMacros returns the bodies of the macros in s, e.g. "name" for ${name} and "secure(key)" for
+// ${secure(key)}. For nested macros, both the outer body (e.g. "${env}.bucket") and the inner
+// macros (e.g. "env") are returned. Escaped macros (\${name}) are ignored.
+func parseMacros(s string) []string {
+	var macros []string
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++ // Skip the escaped character.
+		case strings.HasPrefix(s[i:], "${"):
+			end := macroEnd(s, i+2)
+			if end < 0 {
+				return macros // Unterminated macros are treated as literals.
+			}
+			body := s[i+2 : end]
+			macros = append(macros, body)
+			macros = append(macros, parseMacros(body)...)
+			i = end
+		}
+	}
+	return macros
+}
+
+// macroEnd returns the index of the closing brace of the macro whose body starts at start, or -1.
+func macroEnd(s string, start int) int {
+	depth := 1
+	for i := start; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case strings.HasPrefix(s[i:], "${"):
+			depth++
+			i++
+		case s[i] == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isMacroFunction(body string) bool {
+	return macroFunctionRE.MatchString(body)
+}
+
+// expandMacros substitutes the lookup macros in s with args. It returns false if any macro
+// could not be resolved, for example because it is a macro function or the argument is missing.
+func expandMacros(s string, args map[string]string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			b.WriteString(s[i : i+2])
+			i++
+		case strings.HasPrefix(s[i:], "${"):
+			end := macroEnd(s, i+2)
+			if end < 0 {
+				b.WriteString(s[i:])
+				return b.String(), true
+			}
+			key, ok := expandMacros(s[i+2:end], args)
+			if !ok || isMacroFunction(key) {
+				return "", false
+			}
+			val, ok := args[key]
+			if !ok {
+				return "", false
+			}
+			b.WriteString(val)
+			i = end
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), true
+}
+
+// missingMacroArguments returns the lookup macros that are not covered by args. Nested macros are
+// resolved with args first, so ${${env}.bucket} requires "prod.bucket" when env is "prod". Macro
+// functions are skipped as they are evaluated by CDAP, but lookups nested in their arguments are not.
+func missingMacroArguments(macros []string, args map[string]string) []string {
+	missing := make(map[string]bool)
+	for _, m := range macros {
+		if isMacroFunction(m) {
+			continue
+		}
+		key := m
+		if strings.Contains(m, "${") {
+			var ok bool
+			if key, ok = expandMacros(m, args); !ok {
+				// The unresolved inner macro is reported on its own.
+				continue
+			}
+		}
+		if _, ok := args[key]; !ok {
+			missing[key] = true
+		}
+	}
+
+	var res []string
+	for k := range missing {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// customizeDiffMacroCoverage fails the plan of a program run if its runtime arguments, merged over
+// the preferences CDAP would resolve for the program, do not cover every macro in required_macros.
+func customizeDiffMacroCoverage(_ context.Context, d *schema.ResourceDiff, m interface{}) error {
+	if d.Id() != "" && !d.HasChanges("runtime_arguments", "required_macros") {
+		return nil
+	}
+	if !d.NewValueKnown("required_macros") || !d.NewValueKnown("runtime_arguments") {
+		return nil
+	}
+	var macros []string
+	for _, v := range d.Get("required_macros").(*schema.Set).List() {
+		macros = append(macros, v.(string))
+	}
+	if len(macros) == 0 {
+		return nil
+	}
+
+	config := m.(*Config)
+	args, err := getResolvedPreferences(config, d)
+	if err != nil {
+		return fmt.Errorf("failed to resolve preferences: %v", err)
+	}
+	for k, v := range d.Get("runtime_arguments").(map[string]interface{}) {
+		args[k] = v.(string)
+	}
+
+	if missing := missingMacroArguments(macros, args); len(missing) > 0 {
+		return fmt.Errorf("runtime arguments and preferences do not cover the macros: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// getResolvedPreferences returns the preferences CDAP would apply to a run of the program. If the
+// application is not deployed yet, the namespace (or else instance) preferences are returned.
+// https://docs.cdap.io/cdap/current/en/reference-manual/http-restful-api/preferences.html
+func getResolvedPreferences(config *Config, d resourceGetter) (map[string]string, error) {
+	addrs := []string{
+		urlJoin(getProgramAddr(config, d), "/preferences"),
+		urlJoin(config.host, "/v3/namespaces", d.Get("namespace").(string), "/preferences"),
+		urlJoin(config.host, "/v3/preferences"),
+	}
+
+	for _, addr := range addrs {
+		req, err := http.NewRequest(http.MethodGet, addr+"?resolved=true", nil)
+		if err != nil {
+			return nil, err
+		}
+		b, err := httpCall(config, req)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		prefs := make(map[string]string)
+		if err := json.Unmarshal(b, &prefs); err != nil {
+			return nil, err
+		}
+		return prefs, nil
+	}
+	return make(map[string]string), nil
+}
@@ -18,12 +18,15 @@ package cdap
 import (
 	"context"
 	"fmt"
+	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
 	"google.golang.org/api/option"
 )
 
@@ -43,16 +46,61 @@ func Provider(version string) *schema.Provider {
 				Optional:    true,
 				Description: "The OAuth token to use for all http calls to the instance.",
 			},
+			"failure_log_lines": &schema.Schema{
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     20,
+				Description: "The number of ERROR and WARN log lines of a failed program run to include in the error. Set to 0 to not fetch logs.",
+			},
+			"run_correlation_argument": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     defaultRunCorrelationArgument,
+				Description: "The runtime argument used to find the run of a started program on CDAP versions before 6.0, which do not return the run id when starting programs. It is not added on later versions. Set to an empty string to never add it, which requires CDAP to return run ids.",
+			},
+			"artifact_upload_chunked": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Whether to upload artifact JARs with chunked transfer encoding instead of sending their length up front.",
+			},
+			"gcs_credentials": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "The JSON key of the service account used to read artifacts from GCS. If not provided, the application default credentials are used. Public objects can be read without any credentials.",
+			},
+			"gcs_use_token": &schema.Schema{
+				Type:          schema.TypeBool,
+				Optional:      true,
+				Default:       false,
+				ConflictsWith: []string{"gcs_credentials"},
+				Description:   "Whether to also send the token of the instance to GCS to read artifacts, e.g. if it is a Google OAuth token with access to the bucket. Defaults to false, as the token is otherwise only sent to the instance.",
+			},
+			"gcs_endpoint": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The endpoint of the GCS JSON API, e.g. http://localhost:4443/storage/v1/ for a local emulator.",
+			},
 		},
 		ConfigureFunc: configureProvider(version),
 		ResourcesMap: map[string]*schema.Resource{
 			"cdap_application":           resourceApplication(),
+			"cdap_pipeline":              resourcePipeline(),
+			"cdap_pipeline_preview":      resourcePipelinePreview(),
+			"cdap_pipeline_trigger":      resourcePipelineTrigger(),
 			"cdap_streaming_program_run": resourceStreamingProgramRun(),
+			"cdap_program":               resourceProgram(),
+			"cdap_program_group":         resourceProgramGroup(),
+			"cdap_program_run":           resourceProgramRun(),
+			"cdap_artifact_bundle":       resourceArtifactBundle(),
 			"cdap_gcs_artifact":          resourceGCSArtifact(),
 			"cdap_local_artifact":        resourceLocalArtifact(),
+			"cdap_maven_artifact":        resourceMavenArtifact(),
 			"cdap_namespace":             resourceNamespace(),
 			"cdap_namespace_preferences": resourceNamespacePreferences(),
 			"cdap_profile":               resourceProfile(),
+			"cdap_schedule":              resourceSchedule(),
 			"cdap_oauth_provider":        resourceOAuthProvider(),
 			"cdap_oauth_credential":      resourceOAuthCredential(),
 		},
@@ -60,16 +108,31 @@ func Provider(version string) *schema.Provider {
 			"cdap_oauth_url":                   dataSourceOAuthURL(),
 			"cdap_oauth_credential":            dataSourceOAuthCredential(),
 			"cdap_oauth_credential_validation": dataSourceOAuthCredentialValidation(),
+			"cdap_metrics":                     dataSourceMetrics(),
+			"cdap_program_runs":                dataSourceProgramRuns(),
+			"cdap_program_status":              dataSourceProgramStatus(),
 		},
 	}
 }
 
 // Config provides service configuration for service clients.
 type Config struct {
-	host          string
-	httpClient    *http.Client
-	storageClient *storage.Client
-	userAgent     string
+	host       string
+	httpClient *http.Client
+	// repositoryClient downloads artifacts from Maven repositories, which must not receive the
+	// token of CDAP.
+	repositoryClient *http.Client
+	storageClient    *storage.Client
+	userAgent        string
+	failureLogLines  int
+
+	runCorrelationArg     string
+	artifactUploadChunked bool
+
+	// versionOnce guards cdapVersion, which is only fetched when it is first needed.
+	versionOnce sync.Once
+	cdapVersion string
+	versionErr  error
 }
 
 func configureProvider(version string) schema.ConfigureFunc {
@@ -85,7 +148,7 @@ func configureProvider(version string) schema.ConfigureFunc {
 		}
 		httpClient.Timeout = 30 * time.Minute
 
-		storageClient, err := storage.NewClient(ctx, option.WithScopes(storage.ScopeReadOnly), option.WithoutAuthentication())
+		storageClient, err := newStorageClient(ctx, d)
 		if err != nil {
 			return nil, err
 		}
@@ -93,10 +156,36 @@ func configureProvider(version string) schema.ConfigureFunc {
 		userAgent := fmt.Sprintf("terraform-provider-cdap/%s", version)
 
 		return &Config{
-			host:          d.Get("host").(string),
-			httpClient:    httpClient,
-			storageClient: storageClient,
-			userAgent:     userAgent,
+			host:             d.Get("host").(string),
+			httpClient:       httpClient,
+			repositoryClient: &http.Client{Timeout: 30 * time.Minute},
+			storageClient:    storageClient,
+			userAgent:        userAgent,
+			failureLogLines:  d.Get("failure_log_lines").(int),
+
+			runCorrelationArg:     d.Get("run_correlation_argument").(string),
+			artifactUploadChunked: d.Get("artifact_upload_chunked").(bool),
 		}, nil
 	}
 }
+
+// newStorageClient creates the client used to read artifacts from GCS. Without credentials, only
+// public objects can be read.
+func newStorageClient(ctx context.Context, d *schema.ResourceData) (*storage.Client, error) {
+	opts := []option.ClientOption{option.WithScopes(storage.ScopeReadOnly)}
+	if endpoint, ok := d.GetOk("gcs_endpoint"); ok {
+		opts = append(opts, option.WithEndpoint(endpoint.(string)))
+	}
+	if creds, ok := d.GetOk("gcs_credentials"); ok {
+		opts = append(opts, option.WithCredentialsJSON([]byte(creds.(string))))
+	} else if token, ok := d.GetOk("token"); ok && d.Get("gcs_use_token").(bool) {
+		opts = append(opts, option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{
+			AccessToken: token.(string),
+			TokenType:   "Bearer",
+		})))
+	} else if _, err := google.FindDefaultCredentials(ctx, storage.ScopeReadOnly); err != nil {
+		log.Printf("no default credentials found, reading GCS objects without authentication: %v", err)
+		opts = append(opts, option.WithoutAuthentication())
+	}
+	return storage.NewClient(ctx, opts...)
+}
@@ -15,7 +15,9 @@
 package cdap
 
 import (
+	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"strings"
 
@@ -32,6 +34,8 @@ func resourceApplication() *schema.Resource {
 		Delete: resourceApplicationDelete,
 		Exists: resourceApplicationExists,
 
+		CustomizeDiff: resourceApplicationCustomizeDiff,
+
 		Schema: map[string]*schema.Schema{
 			"namespace": {
 				Type:        schema.TypeString,
@@ -59,6 +63,12 @@ func resourceApplication() *schema.Resource {
 					return json
 				},
 			},
+			"macros": {
+				Type:        schema.TypeSet,
+				Computed:    true,
+				Description: "The macros referenced by the spec, e.g. input.path for ${input.path}. Macro functions such as secure(key) and nested macros are included as written.",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
 		},
 	}
 }
@@ -66,31 +76,61 @@ func resourceApplication() *schema.Resource {
 func resourceApplicationCreate(d *schema.ResourceData, m interface{}) error {
 	config := m.(*Config)
 	name := d.Get("name").(string)
-	addr := urlJoin(config.host, "/v3/namespaces", d.Get("namespace").(string), "/apps", name)
 
 	body := strings.NewReader(d.Get("spec").(string))
-
-	req, err := http.NewRequest(http.MethodPut, addr, body)
-	if err != nil {
+	if err := deployApplication(config, d.Get("namespace").(string), name, body); err != nil {
 		return err
 	}
 
-	if _, err := httpCall(config, req); err != nil {
+	d.SetId(name)
+	return setApplicationMacros(d)
+}
+
+// deployApplication creates or updates the named application from the given spec.
+func deployApplication(config *Config, namespace, name string, spec io.Reader) error {
+	addr := urlJoin(config.host, "/v3/namespaces", namespace, "/apps", name)
+
+	req, err := http.NewRequest(http.MethodPut, addr, spec)
+	if err != nil {
 		return err
 	}
 
-	d.SetId(name)
-	return nil
+	_, err = httpCall(config, req)
+	return err
 }
 
 func resourceApplicationRead(d *schema.ResourceData, m interface{}) error {
-	return nil
+	return setApplicationMacros(d)
+}
+
+func setApplicationMacros(d *schema.ResourceData) error {
+	macros, err := specMacros(d.Get("spec").(string))
+	if err != nil {
+		return err
+	}
+	return d.Set("macros", macros)
+}
+
+// resourceApplicationCustomizeDiff computes the macros at plan time so that program runs can
+// check them before the application is deployed.
+func resourceApplicationCustomizeDiff(_ context.Context, d *schema.ResourceDiff, m interface{}) error {
+	if !d.HasChange("spec") || !d.NewValueKnown("spec") {
+		return nil
+	}
+	macros, err := specMacros(d.Get("spec").(string))
+	if err != nil {
+		return err
+	}
+	return d.SetNew("macros", macros)
 }
 
 func resourceApplicationDelete(d *schema.ResourceData, m interface{}) error {
 	config := m.(*Config)
-	name := d.Get("name").(string)
-	addr := urlJoin(config.host, "/v3/namespaces", d.Get("namespace").(string), "/apps", name)
+	return deleteApplication(config, d.Get("namespace").(string), d.Get("name").(string))
+}
+
+func deleteApplication(config *Config, namespace, name string) error {
+	addr := urlJoin(config.host, "/v3/namespaces", namespace, "/apps", name)
 
 	req, err := http.NewRequest(http.MethodDelete, addr, nil)
 	if err != nil {
@@ -102,8 +142,11 @@ func resourceApplicationDelete(d *schema.ResourceData, m interface{}) error {
 
 func resourceApplicationExists(d *schema.ResourceData, m interface{}) (bool, error) {
 	config := m.(*Config)
-	name := d.Get("name").(string)
-	addr := urlJoin(config.host, "/v3/namespaces", d.Get("namespace").(string), "/apps")
+	return applicationExists(config, d.Get("namespace").(string), d.Get("name").(string))
+}
+
+func applicationExists(config *Config, namespace, name string) (bool, error) {
+	addr := urlJoin(config.host, "/v3/namespaces", namespace, "/apps")
 	req, err := http.NewRequest(http.MethodGet, addr, nil)
 	if err != nil {
 		return false, err
@@ -0,0 +1,525 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/google/uuid"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"google.golang.org/api/iterator"
+)
+
+// Matches GCS prefixes in the form [matched string, bucket name, prefix].
+var gcsPrefixRE = regexp.MustCompile(`^gs://([^/]+)(?:/(.*))?$`)
+
+// resourceArtifactBundle uploads the name-version.jar and name-version.json pairs of a local
+// directory or GCS prefix, e.g. the output of a plugin build. The hashes of each artifact are
+// tracked so that only the artifacts that changed are uploaded again.
+func resourceArtifactBundle() *schema.Resource {
+	return &schema.Resource{
+		CreateContext: resourceArtifactBundleCreate,
+		Read:          resourceArtifactBundleRead,
+		Update:        resourceArtifactBundleUpdate,
+		Delete:        resourceArtifactBundleDelete,
+
+		CustomizeDiff: resourceArtifactBundleCustomizeDiff,
+
+		Schema: map[string]*schema.Schema{
+			"namespace": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "The name of the namespace in which this resource belongs. If not provided, the default namespace is used.",
+				DefaultFunc: func() (interface{}, error) {
+					return defaultNamespace, nil
+				},
+			},
+			"path": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "The local directory or GCS prefix, e.g. gs://bucket/plugins/, with the name-version.jar and name-version.json files of the artifacts. Subdirectories are not searched. Changing it only uploads the artifacts whose files differ.",
+			},
+			"include": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "The glob patterns of the JAR file names to upload, e.g. *-transform-*.jar. If not provided, all JARs are uploaded.",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"exclude": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "The glob patterns of the JAR file names not to upload. They take precedence over include.",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"concurrency": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      4,
+				Description:  "The maximum number of artifacts to upload at a time. Artifacts are uploaded after the artifacts of the bundle they extend.",
+				ValidateFunc: validation.IntAtLeast(1),
+			},
+			"artifacts": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Description: "The hashes of the JAR and JSON config of the uploaded artifacts, keyed by name/version. An artifact is uploaded again when its hashes change, and deleted when it is no longer in the bundle. Every plan reads each local file in full to hash it, or fetches the CRC32C of each GCS object, which can take a while for bundles of many large JARs.",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+		},
+	}
+}
+
+// artifactBundleSource lists and reads the files of a bundle.
+type artifactBundleSource struct {
+	list       func(ctx context.Context, config *Config, dir string) ([]string, error)
+	hash       contentHashFunc
+	loadConfig artifactConfigFunc
+	openJar    func(ctx context.Context, config *Config, path string) (io.ReadCloser, int64, error)
+}
+
+var localArtifactBundleSource = &artifactBundleSource{
+	list:       listLocalDir,
+	hash:       fileSHA256,
+	loadConfig: loadLocalArtifactConfig,
+	openJar:    openLocalFile,
+}
+
+var gcsArtifactBundleSource = &artifactBundleSource{
+	list:       listGCSPrefix,
+	hash:       objectCRC32C,
+	loadConfig: loadGCSArtifactConfig,
+	openJar:    openGCSObject,
+}
+
+func getArtifactBundleSource(dir string) *artifactBundleSource {
+	if strings.HasPrefix(dir, "gs://") {
+		return gcsArtifactBundleSource
+	}
+	return localArtifactBundleSource
+}
+
+// bundleArtifact is an artifact of a bundle.
+type bundleArtifact struct {
+	name       string
+	version    string
+	jarPath    string
+	configPath string
+	// hash is the hash of the JAR and the hash of the JSON config, separated by a comma.
+	hash   string
+	config *artifactConfig
+}
+
+func (a *bundleArtifact) key() string {
+	return a.name + "/" + a.version
+}
+
+// discoverArtifactBundle returns the artifacts of the bundle, sorted by name and version.
+func discoverArtifactBundle(ctx context.Context, config *Config, d resourceGetter) ([]*bundleArtifact, error) {
+	dir := d.Get("path").(string)
+	src := getArtifactBundleSource(dir)
+	files, err := src.list(ctx, config, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %v: %w", dir, err)
+	}
+	exists := make(map[string]bool)
+	for _, f := range files {
+		exists[f] = true
+	}
+
+	var artifacts []*bundleArtifact
+	for _, f := range files {
+		base := path.Base(filepath.ToSlash(f))
+		if !strings.HasSuffix(base, ".jar") {
+			continue
+		}
+		ok, err := matchArtifactBundleGlobs(base, toStringList(d.Get("include").([]interface{})), toStringList(d.Get("exclude").([]interface{})))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		m := jarFileNameRE.FindStringSubmatch(base)
+		if m == nil {
+			return nil, fmt.Errorf("%v does not follow the name-version.jar convention; exclude it to skip it", f)
+		}
+		a := &bundleArtifact{name: m[1], version: m[2], jarPath: f, configPath: strings.TrimSuffix(f, ".jar") + ".json"}
+		if !exists[a.configPath] {
+			return nil, fmt.Errorf("%v has no JSON config %v; exclude it to skip it", f, a.configPath)
+		}
+
+		var hashes []string
+		for _, p := range []string{a.jarPath, a.configPath} {
+			h, err := src.hash(ctx, config, p)
+			if err != nil {
+				return nil, fmt.Errorf("failed to hash %v: %v", p, err)
+			}
+			hashes = append(hashes, h)
+		}
+		a.hash = strings.Join(hashes, ",")
+		artifacts = append(artifacts, a)
+	}
+	sort.Slice(artifacts, func(i, j int) bool { return artifacts[i].key() < artifacts[j].key() })
+	return artifacts, nil
+}
+
+// matchArtifactBundleGlobs returns whether the file name matches any of the include patterns, or
+// there are none, and none of the exclude patterns.
+func matchArtifactBundleGlobs(name string, include, exclude []string) (bool, error) {
+	included := len(include) == 0
+	for _, p := range include {
+		ok, err := path.Match(p, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid include pattern %q: %v", p, err)
+		}
+		included = included || ok
+	}
+	for _, p := range exclude {
+		ok, err := path.Match(p, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid exclude pattern %q: %v", p, err)
+		}
+		if ok {
+			return false, nil
+		}
+	}
+	return included, nil
+}
+
+func listLocalDir(_ context.Context, _ *Config, dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, info := range infos {
+		if !info.IsDir() {
+			files = append(files, filepath.Join(dir, info.Name()))
+		}
+	}
+	return files, nil
+}
+
+func listGCSPrefix(ctx context.Context, config *Config, dir string) ([]string, error) {
+	matches := gcsPrefixRE.FindStringSubmatch(dir)
+	if matches == nil {
+		return nil, fmt.Errorf("invalid GCS prefix %q: want gs://bucket/prefix", dir)
+	}
+	bucket, prefix := matches[1], matches[2]
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	var files []string
+	it := config.storageClient.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix, Delimiter: "/"})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Subdirectories are returned as prefixes without a name.
+		if attrs.Name != "" {
+			files = append(files, "gs://"+bucket+"/"+attrs.Name)
+		}
+	}
+	// Prefixes only exist while there are objects under them, like directories that are not empty.
+	if len(files) == 0 && prefix != "" {
+		return nil, os.ErrNotExist
+	}
+	return files, nil
+}
+
+func openLocalFile(_ context.Context, _ *Config, path string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+func openGCSObject(ctx context.Context, config *Config, path string) (io.ReadCloser, int64, error) {
+	obj, err := gcsObject(config.storageClient, path)
+	if err != nil {
+		return nil, 0, err
+	}
+	r, err := obj.NewReader(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	return r, r.Attrs.Size, nil
+}
+
+// resourceArtifactBundleCustomizeDiff hashes the artifacts of the bundle during plan, so that
+// changed, added and removed artifacts are planned as an update.
+func resourceArtifactBundleCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	config := m.(*Config)
+	for _, k := range []string{"path", "include", "exclude"} {
+		if !d.NewValueKnown(k) {
+			return d.SetNewComputed("artifacts")
+		}
+	}
+	// The patterns are checked even if the files are not listed yet.
+	if _, err := matchArtifactBundleGlobs("", toStringList(d.Get("include").([]interface{})), toStringList(d.Get("exclude").([]interface{}))); err != nil {
+		return err
+	}
+	artifacts, err := discoverArtifactBundle(ctx, config, d)
+	if d.Id() == "" && (errors.Is(err, os.ErrNotExist) || errors.Is(err, storage.ErrBucketNotExist)) {
+		// The directory or prefix may be created during apply, in which case it is read on create.
+		return d.SetNewComputed("artifacts")
+	}
+	if err != nil {
+		return err
+	}
+
+	hashes := make(map[string]string)
+	for _, a := range artifacts {
+		hashes[a.key()] = a.hash
+	}
+	old, _ := d.GetChange("artifacts")
+	if reflect.DeepEqual(toStringMap(old.(map[string]interface{})), hashes) {
+		return nil
+	}
+	return d.SetNew("artifacts", hashes)
+}
+
+// resourceArtifactBundleCreate uploads the artifacts of the bundle. If only some of them could be
+// uploaded, the bundle is created with those rather than failing, as a failed create would replace
+// the whole bundle. The others are then planned as an update.
+func resourceArtifactBundleCreate(_ context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	// The id does not include the path, which can be changed in place.
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return diag.Errorf("error generating uuid for the bundle id: %v", err)
+	}
+	d.SetId(d.Get("namespace").(string) + "/" + id.String())
+	// Nothing is uploaded yet, while the planned hashes would otherwise be recorded on errors.
+	d.Set("artifacts", map[string]string{})
+	err = resourceArtifactBundleUpdate(d, m)
+	if err == nil {
+		return nil
+	}
+	if len(d.Get("artifacts").(map[string]interface{})) == 0 {
+		d.SetId("")
+		return diag.FromErr(err)
+	}
+	return diag.Diagnostics{{
+		Severity: diag.Warning,
+		Summary:  "Only some artifacts of the bundle were uploaded, the others will be uploaded by the next apply",
+		Detail:   err.Error(),
+	}}
+}
+
+// resourceArtifactBundleUpdate deletes the artifacts that were removed from the bundle or changed,
+// and uploads the changed and added ones. The artifacts that were uploaded are recorded even if
+// others fail, so that the next apply only retries the failed ones.
+func resourceArtifactBundleUpdate(d *schema.ResourceData, m interface{}) error {
+	ctx := context.Background()
+	config := m.(*Config)
+	namespace := d.Get("namespace").(string)
+	src := getArtifactBundleSource(d.Get("path").(string))
+
+	artifacts, err := discoverArtifactBundle(ctx, config, d)
+	if err != nil {
+		return err
+	}
+	oldHashes, _ := d.GetChange("artifacts")
+	uploaded := toStringMap(oldHashes.(map[string]interface{}))
+	current := make(map[string]string)
+	var changed []*bundleArtifact
+	for _, a := range artifacts {
+		current[a.key()] = a.hash
+		if uploaded[a.key()] != a.hash {
+			changed = append(changed, a)
+		}
+	}
+
+	for _, a := range changed {
+		if a.config, err = src.loadConfig(ctx, config, a.configPath); err != nil {
+			return fmt.Errorf("failed to load %v: %v", a.configPath, err)
+		}
+	}
+	levels, err := artifactBundleLevels(changed)
+	if err != nil {
+		return err
+	}
+
+	var stale []string
+	for k, h := range uploaded {
+		if current[k] != h {
+			stale = append(stale, k)
+		}
+	}
+	sort.Strings(stale)
+	for _, k := range stale {
+		parts := strings.SplitN(k, "/", 2)
+		if err := deleteArtifact(config, namespace, parts[0], parts[1]); err != nil && !isNotFound(err) {
+			d.Set("artifacts", uploaded)
+			return fmt.Errorf("failed to delete artifact %v: %v", k, err)
+		}
+		delete(uploaded, k)
+	}
+
+	for _, level := range levels {
+		errs := runInParallelLimit(len(level), d.Get("concurrency").(int), func(i int) error {
+			return uploadBundleArtifact(ctx, config, src, namespace, level[i])
+		})
+		var msgs []string
+		for i, err := range errs {
+			if err != nil {
+				msgs = append(msgs, fmt.Sprintf("%v: %v", level[i].key(), err))
+				continue
+			}
+			uploaded[level[i].key()] = level[i].hash
+		}
+		if len(msgs) > 0 {
+			d.Set("artifacts", uploaded)
+			return fmt.Errorf("failed to upload artifacts:\n%v", strings.Join(msgs, "\n"))
+		}
+	}
+
+	d.Set("artifacts", uploaded)
+	return resourceArtifactBundleRead(d, m)
+}
+
+// artifactBundleLevels groups the artifacts so that each group only extends artifacts of earlier
+// groups or artifacts outside of them, e.g. system artifacts.
+func artifactBundleLevels(artifacts []*bundleArtifact) ([][]*bundleArtifact, error) {
+	byName := make(map[string][]*bundleArtifact)
+	for _, a := range artifacts {
+		byName[a.name] = append(byName[a.name], a)
+	}
+
+	levelOf := make(map[*bundleArtifact]int)
+	visiting := make(map[*bundleArtifact]bool)
+	var visit func(a *bundleArtifact) (int, error)
+	visit = func(a *bundleArtifact) (int, error) {
+		if l, ok := levelOf[a]; ok {
+			return l, nil
+		}
+		if visiting[a] {
+			return 0, fmt.Errorf("artifact %v extends itself through its parents", a.key())
+		}
+		visiting[a] = true
+		level := 0
+		for _, p := range a.config.Parents {
+			m := artifactRangeRE.FindStringSubmatch(strings.TrimSpace(p))
+			if m == nil || strings.EqualFold(m[1], "system") {
+				continue
+			}
+			for _, parent := range byName[m[2]] {
+				l, err := visit(parent)
+				if err != nil {
+					return 0, err
+				}
+				if l+1 > level {
+					level = l + 1
+				}
+			}
+		}
+		visiting[a] = false
+		levelOf[a] = level
+		return level, nil
+	}
+
+	var levels [][]*bundleArtifact
+	for _, a := range artifacts {
+		l, err := visit(a)
+		if err != nil {
+			return nil, err
+		}
+		for len(levels) <= l {
+			levels = append(levels, nil)
+		}
+	}
+	for _, a := range artifacts {
+		levels[levelOf[a]] = append(levels[levelOf[a]], a)
+	}
+	return levels, nil
+}
+
+func uploadBundleArtifact(ctx context.Context, config *Config, src *artifactBundleSource, namespace string, ba *bundleArtifact) error {
+	jar, size, err := src.openJar(ctx, config, ba.jarPath)
+	if err != nil {
+		return err
+	}
+	defer jar.Close()
+
+	a := &artifact{
+		name:    ba.name,
+		version: ba.version,
+		config:  ba.config,
+		jar:     jar,
+		jarSize: size,
+	}
+	addr := urlJoin(config.host, "/v3/namespaces", namespace, "/artifacts", a.name)
+	if err := uploadJar(config, addr, a); err != nil {
+		return err
+	}
+	return uploadProps(config, addr, a)
+}
+
+// resourceArtifactBundleRead removes the artifacts that no longer exist in CDAP, so that they are
+// uploaded again.
+func resourceArtifactBundleRead(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	namespace := d.Get("namespace").(string)
+	hashes := toStringMap(d.Get("artifacts").(map[string]interface{}))
+	for k := range hashes {
+		parts := strings.SplitN(k, "/", 2)
+		_, err := getArtifactInfo(config, namespace, parts[0], parts[1])
+		if isNotFound(err) {
+			delete(hashes, k)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return d.Set("artifacts", hashes)
+}
+
+func resourceArtifactBundleDelete(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	namespace := d.Get("namespace").(string)
+	for k := range d.Get("artifacts").(map[string]interface{}) {
+		parts := strings.SplitN(k, "/", 2)
+		if err := deleteArtifact(config, namespace, parts[0], parts[1]); err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to delete artifact %v: %v", k, err)
+		}
+	}
+	return nil
+}
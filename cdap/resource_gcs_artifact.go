@@ -16,33 +16,47 @@ package cdap
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"regexp"
+	"strconv"
 
 	"cloud.google.com/go/storage"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
-var bucketPathRE = regexp.MustCompile(`^gs://(.+)/(.+)$`)
+// Matches GCS paths in the form [matched string, bucket name, object name, generation]. The
+// generation is optional and pins the object to a version, as in gsutil.
+var bucketPathRE = regexp.MustCompile(`^gs://([^/]+)/(.+?)(?:#(\d+))?$`)
 
 // resourceGCSArtifact supports deploying an artifact by providing a GCS path.
 // We need to use references like GCS or filepaths to avoid needing to pass and
 // store the entire JAR's contents as a string.
 func resourceGCSArtifact() *schema.Resource {
-	return &schema.Resource{
+	r := &schema.Resource{
 		Create: resourceGCSArtifactCreate,
-		Read:   resourceLocalArtifactRead,
+		Read:   resourceGCSArtifactRead,
+		Update: resourceGCSArtifactUpdate,
 		Delete: resourceLocalArtifactDelete,
-		Exists: resourceLocalArtifactExists,
+		Importer: &schema.ResourceImporter{
+			State: resourceArtifactImport,
+		},
+
+		CustomizeDiff: customdiff.All(
+			customizeDiffArtifactContent(gcsArtifactHashes, objectCRC32C),
+			customizeDiffArtifactConfig("json_config_path", loadGCSArtifactConfig),
+			customizeDiffArtifactIdentity(openGCSJar, "jar_crc32c"),
+		),
 
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "The name of the artifact.",
+				Description: "The name of the artifact. If not provided, it is inferred from the Bundle-SymbolicName of the JAR manifest or else the name-version.jar file name of the JAR.",
 			},
 			"namespace": {
 				Type:        schema.TypeString,
@@ -53,54 +67,101 @@ func resourceGCSArtifact() *schema.Resource {
 					return defaultNamespace, nil
 				},
 			},
-			// Technically, we could omit the version in the API call because CDAP will infer the
-			// version from the jar. However, forcing the user to specify the version makes dealing
-			// with the resource easier because other API calls require it.
+			// The version is inferred during plan rather than left to CDAP, as the other API calls
+			// require it.
 			"version": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "The version of the artifact. Must match the version in the JAR manifest.",
+				Description: "The version of the artifact. Must match the Bundle-Version of the JAR manifest, if any. If not provided, it is inferred from the manifest or else the name-version.jar file name of the JAR.",
 			},
 			"jar_binary_path": {
 				Type:        schema.TypeString,
 				Required:    true,
-				ForceNew:    true,
-				Description: "The GCS path to the JAR binary for the artifact.",
+				Description: "The GCS path to the JAR binary for the artifact, e.g. gs://bucket/path/to/plugin.jar. Append #generation to pin a version of the object. Moving the objects does not replace the artifact unless their content changes.",
 			},
 			"json_config_path": {
 				Type:        schema.TypeString,
 				Required:    true,
-				ForceNew:    true,
-				Description: "The GCS path to the JSON config of the artifact.",
+				Description: "The GCS path to the JSON config of the artifact. Append #generation to pin a version of the object.",
+			},
+			"jar_crc32c": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The CRC32C checksum of the JAR binary object. The artifact is replaced when it changes.",
+			},
+			"config_crc32c": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The CRC32C checksum of the JSON config object.",
 			},
 		},
 	}
+	for k, v := range artifactInfoSchema() {
+		r.Schema[k] = v
+	}
+	return r
+}
+
+// Maps the path attributes of a GCS artifact to the attributes with the checksum of their content.
+// The checksums are part of the object metadata, so the objects do not need to be downloaded.
+var gcsArtifactHashes = map[string]string{
+	"jar_binary_path":  "jar_crc32c",
+	"json_config_path": "config_crc32c",
 }
 
 func resourceGCSArtifactCreate(d *schema.ResourceData, m interface{}) error {
 	ctx := context.Background()
 	config := m.(*Config)
 
-	a, err := loadGCSArtifact(ctx, d, config.storageClient)
+	if err := resolveArtifactIdentity(ctx, config, d, openGCSJar); err != nil {
+		return err
+	}
+	a, err := loadGCSArtifact(ctx, d, config)
 	if err != nil {
 		return err
 	}
-	return uploadArtifact(config, d, a)
+	defer a.jar.Close()
+	if err := uploadArtifact(config, d, a); err != nil {
+		return err
+	}
+	return setArtifactHashes(ctx, config, d, gcsArtifactHashes, objectCRC32C, false)
+}
+
+func resourceGCSArtifactRead(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	return readArtifact(context.Background(), config, d, gcsArtifactHashes, objectCRC32C)
+}
+
+func resourceGCSArtifactUpdate(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	if err := updateArtifactProperties(config, d); err != nil {
+		return err
+	}
+	return resourceGCSArtifactRead(d, m)
+}
+
+func loadGCSArtifactConfig(ctx context.Context, config *Config, path string) (*artifactConfig, error) {
+	b, err := readObject(ctx, config.storageClient, path)
+	if err != nil {
+		return nil, err
+	}
+	return parseArtifactConfig(b)
 }
 
-func loadGCSArtifact(ctx context.Context, d *schema.ResourceData, storageClient *storage.Client) (*artifact, error) {
-	jar, err := readObject(ctx, storageClient, d.Get("jar_binary_path").(string))
+func loadGCSArtifact(ctx context.Context, d *schema.ResourceData, config *Config) (*artifact, error) {
+	conf, err := loadGCSArtifactConfig(ctx, config, d.Get("json_config_path").(string))
 	if err != nil {
 		return nil, err
 	}
 
-	confb, err := readObject(ctx, storageClient, d.Get("json_config_path").(string))
+	obj, err := gcsObject(config.storageClient, d.Get("jar_binary_path").(string))
 	if err != nil {
 		return nil, err
 	}
-	conf := new(artifactConfig)
-	if err := json.Unmarshal(confb, conf); err != nil {
+	jar, err := obj.NewReader(ctx)
+	if err != nil {
 		return nil, err
 	}
 
@@ -108,21 +169,87 @@ func loadGCSArtifact(ctx context.Context, d *schema.ResourceData, storageClient
 		name:    d.Get("name").(string),
 		version: d.Get("version").(string),
 		jar:     jar,
+		jarSize: jar.Attrs.Size,
 		config:  conf,
 	}, nil
 }
 
-func readObject(ctx context.Context, storageClient *storage.Client, path string) ([]byte, error) {
-	// matches is in the form [matched substring, bucket name, object name].
+func gcsObject(storageClient *storage.Client, path string) (*storage.ObjectHandle, error) {
 	matches := bucketPathRE.FindStringSubmatch(path)
-	if len(matches) != 3 {
-		return nil, fmt.Errorf("unexpected bucket path: got %q submatches, want 3", len(matches))
+	if matches == nil {
+		return nil, fmt.Errorf("invalid GCS path %q: want gs://bucket/object or gs://bucket/object#generation", path)
+	}
+	obj := storageClient.Bucket(matches[1]).Object(matches[2])
+	if matches[3] != "" {
+		gen, err := strconv.ParseInt(matches[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid generation in GCS path %q: %v", path, err)
+		}
+		obj = obj.Generation(gen)
+	}
+	return obj, nil
+}
+
+func objectCRC32C(ctx context.Context, config *Config, path string) (string, error) {
+	obj, err := gcsObject(config.storageClient, path)
+	if err != nil {
+		return "", err
+	}
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%08x", attrs.CRC32C), nil
+}
+
+// openGCSJar reads the JAR object with range requests, so that its manifest can be read without
+// downloading the whole JAR.
+func openGCSJar(ctx context.Context, config *Config, path string) (*io.SectionReader, io.Closer, error) {
+	obj, err := gcsObject(config.storageClient, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := &objectReaderAt{ctx: ctx, obj: obj.Generation(attrs.Generation)}
+	return io.NewSectionReader(r, 0, attrs.Size), r, nil
+}
+
+// objectReaderAt implements io.ReaderAt for a GCS object. Every read is a separate request, so
+// there is nothing to close.
+type objectReaderAt struct {
+	ctx context.Context
+	obj *storage.ObjectHandle
+}
+
+func (r *objectReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	rr, err := r.obj.NewRangeReader(r.ctx, off, int64(len(b)))
+	if err != nil {
+		return 0, err
+	}
+	defer rr.Close()
+	n, err := io.ReadFull(rr, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (r *objectReaderAt) Close() error {
+	return nil
+}
+
+func readObject(ctx context.Context, storageClient *storage.Client, path string) ([]byte, error) {
+	obj, err := gcsObject(storageClient, path)
+	if err != nil {
+		return nil, err
 	}
-	bucketName, objectPath := matches[1], matches[2]
-	obj := storageClient.Bucket(bucketName).Object(objectPath)
 	r, err := obj.NewReader(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer r.Close()
 	return ioutil.ReadAll(r)
 }
@@ -16,13 +16,23 @@ package cdap
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
@@ -30,18 +40,28 @@ import (
 // We need to use references like GCS or filepaths to avoid needing to pass and
 // store the entire JAR's contents as a string.
 func resourceLocalArtifact() *schema.Resource {
-	return &schema.Resource{
+	r := &schema.Resource{
 		Create: resourceLocalArtifactCreate,
 		Read:   resourceLocalArtifactRead,
+		Update: resourceLocalArtifactUpdate,
 		Delete: resourceLocalArtifactDelete,
-		Exists: resourceLocalArtifactExists,
+		Importer: &schema.ResourceImporter{
+			State: resourceArtifactImport,
+		},
+
+		CustomizeDiff: customdiff.All(
+			customizeDiffArtifactContent(localArtifactHashes, fileSHA256),
+			customizeDiffArtifactConfig("json_config_path", loadLocalArtifactConfig),
+			customizeDiffArtifactIdentity(openLocalJar, "jar_sha256"),
+		),
 
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "The name of the artifact.",
+				Description: "The name of the artifact. If not provided, it is inferred from the Bundle-SymbolicName of the JAR manifest or else the name-version.jar file name of the JAR.",
 			},
 			"namespace": {
 				Type:        schema.TypeString,
@@ -52,50 +72,201 @@ func resourceLocalArtifact() *schema.Resource {
 					return defaultNamespace, nil
 				},
 			},
-			// Technically, we could omit the version in the API call because CDAP will infer the
-			// version from the jar. However, forcing the user to specify the version makes dealing
-			// with the resource easier because other API calls require it.
+			// The version is inferred during plan rather than left to CDAP, as the other API calls
+			// require it.
 			"version": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "The version of the artifact. Must match the version in the JAR manifest.",
+				Description: "The version of the artifact. Must match the Bundle-Version of the JAR manifest, if any. If not provided, it is inferred from the manifest or else the name-version.jar file name of the JAR.",
 			},
 			"jar_binary_path": {
 				Type:        schema.TypeString,
 				Required:    true,
-				ForceNew:    true,
-				Description: "The local path to the JAR binary for the artifact.",
+				Description: "The local path to the JAR binary for the artifact. Moving the files does not replace the artifact unless their content changes.",
 			},
 			"json_config_path": {
 				Type:        schema.TypeString,
 				Required:    true,
-				ForceNew:    true,
 				Description: "The local path to the JSON config of the artifact.",
 			},
+			"jar_sha256": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The SHA-256 hash of the JAR binary. The artifact is replaced when it changes.",
+			},
+			"config_sha256": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The SHA-256 hash of the JSON config.",
+			},
 		},
 	}
+	for k, v := range artifactInfoSchema() {
+		r.Schema[k] = v
+	}
+	return r
+}
+
+// artifactInfoSchema returns the attributes of an artifact as reported by CDAP.
+func artifactInfoSchema() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		"properties": {
+			Type:        schema.TypeMap,
+			Computed:    true,
+			Description: "The properties of the artifact. They are updated in place when they differ from the JSON config.",
+			Elem:        &schema.Schema{Type: schema.TypeString},
+		},
+		"parents": {
+			Type:        schema.TypeList,
+			Computed:    true,
+			Description: "The parent artifact ranges of the artifact, without their scope. The artifact is replaced when they differ from the JSON config.",
+			Elem:        &schema.Schema{Type: schema.TypeString},
+		},
+		"plugins": {
+			Type:        schema.TypeList,
+			Computed:    true,
+			Description: "The plugins provided by the artifact, as type:name.",
+			Elem:        &schema.Schema{Type: schema.TypeString},
+		},
+		"plugins_sha256": {
+			Type:        schema.TypeString,
+			Computed:    true,
+			Description: "The SHA-256 hash of the plugins declared by the JSON config when the JAR was uploaded. The artifact is replaced when they change, as they can only be declared on upload.",
+		},
+	}
+}
+
+// Maps the path attributes of a local artifact to the attributes with the hash of their content.
+var localArtifactHashes = map[string]string{
+	"jar_binary_path":  "jar_sha256",
+	"json_config_path": "config_sha256",
+}
+
+// contentHashFunc returns a hash of the content at path.
+type contentHashFunc func(ctx context.Context, config *Config, path string) (string, error)
+
+func fileSHA256(_ context.Context, _ *Config, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// customizeDiffArtifactContent hashes the files of an artifact during plan, so that the artifact is
+// replaced when the JAR changes even if its path stays the same, e.g. for a rebuilt -SNAPSHOT JAR.
+// Changes of the config are planned by customizeDiffArtifactConfig instead, as only changes of its
+// parents require a new artifact. Files that cannot be read yet, e.g. because another resource
+// creates them, are hashed on create instead.
+func customizeDiffArtifactContent(hashes map[string]string, hash contentHashFunc) schema.CustomizeDiffFunc {
+	return func(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+		config := m.(*Config)
+		for pathKey, hashKey := range hashes {
+			if !d.NewValueKnown(pathKey) {
+				if err := d.SetNewComputed(hashKey); err != nil {
+					return err
+				}
+				continue
+			}
+			old, _ := d.GetChange(hashKey)
+			// Artifacts created before the hash was recorded adopt the current content on refresh.
+			if d.Id() != "" && old.(string) == "" {
+				continue
+			}
+
+			h, err := hash(ctx, config, d.Get(pathKey).(string))
+			if err != nil && d.Id() == "" {
+				if err := d.SetNewComputed(hashKey); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to hash %v: %v", pathKey, err)
+			}
+			if h == old.(string) {
+				continue
+			}
+			if err := d.SetNew(hashKey, h); err != nil {
+				return err
+			}
+			if d.Id() != "" && pathKey == "jar_binary_path" {
+				if err := d.ForceNew(hashKey); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// setArtifactHashes records the hashes of the files of an artifact. If onlyMissing is set, only
+// hashes that were not recorded yet are set and errors are logged rather than returned, as the
+// files may no longer exist after the artifact was uploaded.
+func setArtifactHashes(ctx context.Context, config *Config, d *schema.ResourceData, hashes map[string]string, hash contentHashFunc, onlyMissing bool) error {
+	for pathKey, hashKey := range hashes {
+		if onlyMissing && d.Get(hashKey).(string) != "" {
+			continue
+		}
+		h, err := hash(ctx, config, d.Get(pathKey).(string))
+		if err != nil && onlyMissing {
+			log.Printf("failed to hash %v: %v", pathKey, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to hash %v: %v", pathKey, err)
+		}
+		d.Set(hashKey, h)
+	}
+	return nil
 }
 
 type artifact struct {
 	name    string
 	version string
 	config  *artifactConfig
-	jar     []byte
+	// jar is streamed into the upload request rather than held in memory, as JARs can be large.
+	jar     io.ReadCloser
+	jarSize int64
 }
 
 type artifactConfig struct {
 	Properties map[string]string `json:"properties"`
 	Parents    []string          `json:"parents"`
+	// Plugins declares the plugins of JARs without plugin annotations. They are passed to CDAP as
+	// is, so that fields not known to the provider are kept.
+	Plugins []json.RawMessage `json:"plugins"`
+}
+
+// pluginClass holds the fields every plugin declaration must have.
+type pluginClass struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	ClassName string `json:"className"`
 }
 
 func resourceLocalArtifactCreate(d *schema.ResourceData, m interface{}) error {
+	ctx := context.Background()
 	config := m.(*Config)
+	if err := resolveArtifactIdentity(ctx, config, d, openLocalJar); err != nil {
+		return err
+	}
 	a, err := loadLocalArtifact(d)
 	if err != nil {
 		return err
 	}
-	return uploadArtifact(config, d, a)
+	defer a.jar.Close()
+	if err := uploadArtifact(config, d, a); err != nil {
+		return err
+	}
+	return setArtifactHashes(ctx, config, d, localArtifactHashes, fileSHA256, false)
 }
 
 func uploadArtifact(config *Config, d *schema.ResourceData, a *artifact) error {
@@ -104,7 +275,12 @@ func uploadArtifact(config *Config, d *schema.ResourceData, a *artifact) error {
 	if err := uploadJar(config, addr, a); err != nil {
 		return err
 	}
-	d.SetId(a.name)
+	d.SetId(artifactID(d))
+	h, err := artifactPluginsHash(a.config.Plugins)
+	if err != nil {
+		return err
+	}
+	d.Set("plugins_sha256", h)
 
 	if err := uploadProps(config, addr, a); err != nil {
 		return err
@@ -112,14 +288,44 @@ func uploadArtifact(config *Config, d *schema.ResourceData, a *artifact) error {
 	return nil
 }
 
+// artifactPluginsHash returns the SHA-256 hash of the plugin declarations, ignoring formatting and
+// the order of their fields.
+func artifactPluginsHash(plugins []json.RawMessage) (string, error) {
+	decoded := make([]interface{}, len(plugins))
+	for i, p := range plugins {
+		if err := json.Unmarshal(p, &decoded[i]); err != nil {
+			return "", fmt.Errorf("invalid plugin declaration: %v", err)
+		}
+	}
+	b, err := json.Marshal(decoded)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:]), nil
+}
+
 func uploadJar(config *Config, addr string, a *artifact) error {
-	req, err := http.NewRequest(http.MethodPost, addr, bytes.NewReader(a.jar))
+	body := &progressReader{r: a.jar, name: a.name, size: a.jarSize}
+	req, err := http.NewRequest(http.MethodPost, addr, body)
 	if err != nil {
 		return err
 	}
+	// Chunked requests are sent when the length is unknown.
+	req.ContentLength = a.jarSize
+	if config.artifactUploadChunked {
+		req.ContentLength = -1
+	}
 	req.Header = map[string][]string{}
 	req.Header.Add("Artifact-Version", a.version)
 	req.Header.Add("Artifact-Extends", strings.Join(a.config.Parents, "/"))
+	if len(a.config.Plugins) > 0 {
+		b, err := json.Marshal(a.config.Plugins)
+		if err != nil {
+			return err
+		}
+		req.Header.Add("Artifact-Plugins", string(b))
+	}
 	if _, err := httpCall(config, req); err != nil {
 		return err
 	}
@@ -143,18 +349,71 @@ func uploadProps(config *Config, artifactAddr string, a *artifact) error {
 	return nil
 }
 
+// progressReader logs the progress of an upload every 10 percent.
+type progressReader struct {
+	r          io.Reader
+	name       string
+	size, read int64
+	logged     int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if p.size > 0 {
+		if pct := p.read * 100 / p.size; pct >= p.logged+10 {
+			p.logged = pct - pct%10
+			log.Printf("uploaded %v%% (%v of %v bytes) of artifact %v", pct, p.read, p.size, p.name)
+		}
+	}
+	return n, err
+}
+
+func loadLocalArtifactConfig(_ context.Context, _ *Config, path string) (*artifactConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseArtifactConfig(b)
+}
+
+// parseArtifactConfig parses and validates a JSON config, so that mistakes fail the plan rather
+// than the upload.
+func parseArtifactConfig(b []byte) (*artifactConfig, error) {
+	conf := new(artifactConfig)
+	if err := json.Unmarshal(b, conf); err != nil {
+		return nil, fmt.Errorf("failed to parse artifact config: %v", err)
+	}
+	for _, p := range conf.Parents {
+		if !artifactRangeRE.MatchString(strings.TrimSpace(p)) {
+			return nil, fmt.Errorf("invalid parent %q in artifact config: want [scope:]name[lower,upper), e.g. system:cdap-data-pipeline[6.0.0,7.0.0)", p)
+		}
+	}
+	for i, raw := range conf.Plugins {
+		var p pluginClass
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return nil, fmt.Errorf("invalid plugin %d in artifact config: %v", i, err)
+		}
+		if p.Name == "" || p.Type == "" || p.ClassName == "" {
+			return nil, fmt.Errorf("invalid plugin %d in artifact config: name, type and className are required", i)
+		}
+	}
+	return conf, nil
+}
+
 func loadLocalArtifact(d *schema.ResourceData) (*artifact, error) {
-	jar, err := ioutil.ReadFile(d.Get("jar_binary_path").(string))
+	conf, err := loadLocalArtifactConfig(context.Background(), nil, d.Get("json_config_path").(string))
 	if err != nil {
 		return nil, err
 	}
 
-	confb, err := ioutil.ReadFile(d.Get("json_config_path").(string))
+	jar, err := os.Open(d.Get("jar_binary_path").(string))
 	if err != nil {
 		return nil, err
 	}
-	conf := new(artifactConfig)
-	if err := json.Unmarshal(confb, conf); err != nil {
+	info, err := jar.Stat()
+	if err != nil {
+		jar.Close()
 		return nil, err
 	}
 
@@ -163,17 +422,67 @@ func loadLocalArtifact(d *schema.ResourceData) (*artifact, error) {
 		version: d.Get("version").(string),
 		config:  conf,
 		jar:     jar,
+		jarSize: info.Size(),
 	}, nil
 }
 
 func resourceLocalArtifactRead(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	return readArtifact(context.Background(), config, d, localArtifactHashes, fileSHA256)
+}
+
+func resourceLocalArtifactUpdate(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	if err := updateArtifactProperties(config, d); err != nil {
+		return err
+	}
+	return resourceLocalArtifactRead(d, m)
+}
+
+// updateArtifactProperties sets the properties that were added or changed and deletes the ones
+// that were removed, one key at a time, so that the artifact does not need to be uploaded again.
+func updateArtifactProperties(config *Config, d *schema.ResourceData) error {
+	if !d.HasChange("properties") {
+		return nil
+	}
+	o, n := d.GetChange("properties")
+	oldProps, newProps := toStringMap(o.(map[string]interface{})), toStringMap(n.(map[string]interface{}))
+	addr := urlJoin(config.host, "/v3/namespaces", d.Get("namespace").(string), "/artifacts", d.Get("name").(string), "/versions", d.Get("version").(string), "/properties")
+
+	for k := range oldProps {
+		if _, ok := newProps[k]; ok {
+			continue
+		}
+		req, err := http.NewRequest(http.MethodDelete, urlJoin(addr, url.PathEscape(k)), nil)
+		if err != nil {
+			return err
+		}
+		if _, err := httpCall(config, req); err != nil {
+			return fmt.Errorf("failed to delete artifact property %q: %v", k, err)
+		}
+	}
+	for k, v := range newProps {
+		if old, ok := oldProps[k]; ok && old == v {
+			continue
+		}
+		req, err := http.NewRequest(http.MethodPut, urlJoin(addr, url.PathEscape(k)), strings.NewReader(v))
+		if err != nil {
+			return err
+		}
+		if _, err := httpCall(config, req); err != nil {
+			return fmt.Errorf("failed to set artifact property %q: %v", k, err)
+		}
+	}
 	return nil
 }
 
 func resourceLocalArtifactDelete(d *schema.ResourceData, m interface{}) error {
 	config := m.(*Config)
-	name := d.Get("name").(string)
-	addr := urlJoin(config.host, "/v3/namespaces", d.Get("namespace").(string), "/artifacts", name, "/versions", d.Get("version").(string))
+	return deleteArtifact(config, d.Get("namespace").(string), d.Get("name").(string), d.Get("version").(string))
+}
+
+func deleteArtifact(config *Config, namespace, name, version string) error {
+	addr := urlJoin(config.host, "/v3/namespaces", namespace, "/artifacts", name, "/versions", version)
 
 	req, err := http.NewRequest(http.MethodDelete, addr, nil)
 	if err != nil {
@@ -183,48 +492,204 @@ func resourceLocalArtifactDelete(d *schema.ResourceData, m interface{}) error {
 	return err
 }
 
-func resourceLocalArtifactExists(d *schema.ResourceData, m interface{}) (bool, error) {
-	config := m.(*Config)
-	name := d.Get("name").(string)
-
-	namespace := d.Get("namespace").(string)
-	if exists, err := namespaceExists(config, namespace); err != nil {
-		return false, fmt.Errorf("failed to check for existence of namespace %q: %v", namespace, err)
-	} else if !exists {
-		return false, nil
+func resourceArtifactImport(d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	parts := strings.Split(d.Id(), "/")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("unexpected import id %q: want namespace/name/version", d.Id())
 	}
+	d.Set("namespace", parts[0])
+	d.Set("name", parts[1])
+	d.Set("version", parts[2])
+	return []*schema.ResourceData{d}, nil
+}
 
-	return artifactExists(config, name, namespace)
+func artifactID(d resourceGetter) string {
+	return strings.Join([]string{d.Get("namespace").(string), d.Get("name").(string), d.Get("version").(string)}, "/")
 }
 
-func artifactExists(config *Config, name, namespace string) (bool, error) {
-	addr := urlJoin(config.host, "/v3/namespaces", namespace, "/artifacts")
+// artifactInfo is the detail of an artifact version returned by CDAP.
+type artifactInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Scope   string `json:"scope"`
+	Classes struct {
+		Plugins []struct {
+			Type string `json:"type"`
+			Name string `json:"name"`
+		} `json:"plugins"`
+	} `json:"classes"`
+	Properties map[string]string `json:"properties"`
+	Parents    []*artifactRange  `json:"parents"`
+}
 
-	req, err := http.NewRequest(http.MethodGet, addr, nil)
-	if err != nil {
-		return false, err
+type artifactRange struct {
+	Name  string `json:"name"`
+	Lower struct {
+		Version string `json:"version"`
+	} `json:"lower"`
+	Upper struct {
+		Version string `json:"version"`
+	} `json:"upper"`
+	IsLowerInclusive bool `json:"isLowerInclusive"`
+	IsUpperInclusive bool `json:"isUpperInclusive"`
+}
+
+// String returns the range in the format of artifact configs, e.g. cdap-data-pipeline[6.0.0,7.0.0).
+func (r *artifactRange) String() string {
+	lower, upper := "(", ")"
+	if r.IsLowerInclusive {
+		lower = "["
+	}
+	if r.IsUpperInclusive {
+		upper = "]"
 	}
+	return fmt.Sprintf("%v%v%v,%v%v", r.Name, lower, r.Lower.Version, r.Upper.Version, upper)
+}
+
+// Matches a parent of an artifact config, e.g. system:cdap-data-pipeline[6.0.0,7.0.0), in the form
+// [matched string, scope, name, lower bound, lower version, upper version, upper bound].
+var artifactRangeRE = regexp.MustCompile(`^(?:([^:\[\(]+):)?([^:\[\(]+)([\[\(])([^,]*),([^\]\)]*)([\]\)])$`)
 
+// normalizeArtifactParents returns the parents of an artifact config without their scope and sorted,
+// as CDAP reports parents by name and range only.
+func normalizeArtifactParents(parents []string) []string {
+	res := make([]string, 0, len(parents))
+	for _, p := range parents {
+		p = strings.TrimSpace(p)
+		if m := artifactRangeRE.FindStringSubmatch(p); m != nil {
+			p = m[2] + m[3] + strings.TrimSpace(m[4]) + "," + strings.TrimSpace(m[5]) + m[6]
+		}
+		res = append(res, p)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// getArtifactInfo returns the artifact version in the user scope of the namespace.
+func getArtifactInfo(config *Config, namespace, name, version string) (*artifactInfo, error) {
+	addr := urlJoin(config.host, "/v3/namespaces", namespace, "/artifacts", name, "/versions", version)
+	req, err := http.NewRequest(http.MethodGet, addr+"?scope=USER", nil)
+	if err != nil {
+		return nil, err
+	}
 	b, err := httpCall(config, req)
 	if err != nil {
-		return false, err
+		return nil, fmt.Errorf("failed to get artifact %v version %v: %w", name, version, err)
 	}
+	var info *artifactInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, fmt.Errorf("could not unmarshal artifact: %v", err)
+	}
+	return info, nil
+}
 
-	type artifact struct {
-		Name string `json:"name"`
+// readArtifact records the artifact version as reported by CDAP, or removes it from state if the
+// version no longer exists.
+func readArtifact(ctx context.Context, config *Config, d *schema.ResourceData, hashes map[string]string, hash contentHashFunc) error {
+	info, err := getArtifactInfo(config, d.Get("namespace").(string), d.Get("name").(string), d.Get("version").(string))
+	if isNotFound(err) {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 
-	var artifacts []artifact
-	if err := json.Unmarshal(b, &artifacts); err != nil {
-		return false, err
+	var parents []string
+	for _, p := range info.Parents {
+		parents = append(parents, p.String())
 	}
+	sort.Strings(parents)
+	var plugins []string
+	for _, p := range info.Classes.Plugins {
+		plugins = append(plugins, p.Type+":"+p.Name)
+	}
+	sort.Strings(plugins)
+
+	d.Set("properties", info.Properties)
+	d.Set("parents", parents)
+	d.Set("plugins", plugins)
+	return setArtifactHashes(ctx, config, d, hashes, hash, true)
+}
+
+// artifactConfigFunc loads the JSON config of an artifact from path.
+type artifactConfigFunc func(ctx context.Context, config *Config, path string) (*artifactConfig, error)
 
-	log.Printf("got artifacts: %v", artifacts)
+// customizeDiffArtifactConfig plans the properties and parents of the JSON config when they differ
+// from what CDAP reports, e.g. because the config or the artifact was changed. Properties are
+// updated in place, while the parents and the plugin declarations can only be set by uploading the
+// JAR again.
+func customizeDiffArtifactConfig(pathKey string, load artifactConfigFunc) schema.CustomizeDiffFunc {
+	return func(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+		config := m.(*Config)
+		if !d.NewValueKnown(pathKey) {
+			return setNewComputed(d, "properties", "parents", "plugins_sha256")
+		}
+		conf, err := load(ctx, config, d.Get(pathKey).(string))
+		if err != nil && d.Id() == "" {
+			// The config may be created during apply, in which case it is read on create.
+			return setNewComputed(d, "properties", "parents", "plugins_sha256")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load artifact config: %v", err)
+		}
+
+		props := conf.Properties
+		if props == nil {
+			props = make(map[string]string)
+		}
+		oldProps, _ := d.GetChange("properties")
+		oldParents, _ := d.GetChange("parents")
+		changed := map[string]bool{
+			"properties": !reflect.DeepEqual(toStringMap(oldProps.(map[string]interface{})), props),
+			"parents":    !reflect.DeepEqual(toStringList(oldParents.([]interface{})), normalizeArtifactParents(conf.Parents)),
+		}
+		for k, v := range map[string]interface{}{"properties": props, "parents": normalizeArtifactParents(conf.Parents)} {
+			if !changed[k] {
+				continue
+			}
+			if err := d.SetNew(k, v); err != nil {
+				return err
+			}
+			if d.Id() != "" && k == "parents" {
+				if err := d.ForceNew(k); err != nil {
+					return err
+				}
+			}
+		}
+
+		h, err := artifactPluginsHash(conf.Plugins)
+		if err != nil {
+			return err
+		}
+		oldHash, _ := d.GetChange("plugins_sha256")
+		if oldHash.(string) == h {
+			return nil
+		}
+		if err := d.SetNew("plugins_sha256", h); err != nil {
+			return err
+		}
+		// Artifacts uploaded before the hash was recorded adopt the current declarations.
+		if d.Id() != "" && oldHash.(string) != "" {
+			return d.ForceNew("plugins_sha256")
+		}
+		return nil
+	}
+}
 
-	for _, a := range artifacts {
-		if a.Name == name {
-			return true, nil
+func setNewComputed(d *schema.ResourceDiff, keys ...string) error {
+	for _, k := range keys {
+		if err := d.SetNewComputed(k); err != nil {
+			return err
 		}
 	}
-	return false, nil
+	return nil
+}
+
+func toStringList(l []interface{}) []string {
+	res := make([]string, 0, len(l))
+	for _, v := range l {
+		res = append(res, v.(string))
+	}
+	return res
 }
@@ -0,0 +1,565 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdap
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// Matches Maven coordinates in the form [matched string, group id, artifact id, version].
+var mavenCoordinatesRE = regexp.MustCompile(`^([^:\s]+):([^:\s]+):([^:\s]+)$`)
+
+// resourceMavenArtifact supports deploying an artifact from a Maven repository or an HTTP(S) URL.
+// The JAR is downloaded to a temporary file and verified against the checksum published next to
+// it before it is uploaded to CDAP.
+func resourceMavenArtifact() *schema.Resource {
+	r := &schema.Resource{
+		Create: resourceMavenArtifactCreate,
+		Read:   resourceMavenArtifactRead,
+		Update: resourceMavenArtifactUpdate,
+		Delete: resourceLocalArtifactDelete,
+		Importer: &schema.ResourceImporter{
+			State: resourceArtifactImport,
+		},
+
+		CustomizeDiff: resourceMavenArtifactCustomizeDiff,
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "The name of the artifact. If not provided, the artifact ID of the coordinates or else the name-version.jar file name of jar_url is used.",
+			},
+			"namespace": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "The name of the namespace in which this resource belongs. If not provided, the default namespace is used.",
+				DefaultFunc: func() (interface{}, error) {
+					return defaultNamespace, nil
+				},
+			},
+			"version": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "The version of the artifact. Must match the Bundle-Version of the JAR manifest, if any, which is checked once the JAR is downloaded. If not provided, the resolved version of the coordinates or else the name-version.jar file name of jar_url is used.",
+			},
+			"repository_url": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				RequiredWith: []string{"coordinates"},
+				Description:  "The URL of the Maven repository to download the artifact from, e.g. https://repo.example.com/maven2.",
+			},
+			"coordinates": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ExactlyOneOf: []string{"coordinates", "jar_url"},
+				RequiredWith: []string{"repository_url"},
+				Description:  "The Maven coordinates of the artifact as group:artifact:version. A -SNAPSHOT version resolves to the latest snapshot, and RELEASE or LATEST to the version in the metadata of the repository. The JSON config is downloaded from the .json file next to the JAR.",
+				ValidateFunc: validation.StringMatch(mavenCoordinatesRE, "must be in the form group:artifact:version"),
+			},
+			"jar_url": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				Description: "The URL of the JAR binary, if the artifact is not in a Maven repository. Resolved from the coordinates otherwise.",
+			},
+			"json_config_url": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				Description: "The URL of the JSON config of the artifact. Required with jar_url. Resolved from the coordinates otherwise.",
+			},
+			"headers": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "The HTTP headers to send to the repository, e.g. Authorization. They are not sent to CDAP.",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"jar_checksum": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The checksum of the JAR binary as algorithm:hex, from the .sha256 or .sha1 file next to it. The artifact is replaced when it changes, e.g. when a new snapshot is published.",
+			},
+		},
+	}
+	for k, v := range artifactInfoSchema() {
+		r.Schema[k] = v
+	}
+	return r
+}
+
+type mavenCoordinates struct {
+	groupID    string
+	artifactID string
+	version    string
+}
+
+func parseMavenCoordinates(s string) (*mavenCoordinates, error) {
+	m := mavenCoordinatesRE.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("invalid coordinates %q: want group:artifact:version", s)
+	}
+	return &mavenCoordinates{groupID: m[1], artifactID: m[2], version: m[3]}, nil
+}
+
+// mavenMetadata holds the fields of the maven-metadata.xml files of a repository that are needed to
+// resolve versions. The metadata of an artifact lists its versions, while the metadata of a
+// snapshot version lists the files of the latest snapshot.
+type mavenMetadata struct {
+	Versioning struct {
+		Latest   string `xml:"latest"`
+		Release  string `xml:"release"`
+		Snapshot struct {
+			Timestamp   string `xml:"timestamp"`
+			BuildNumber int    `xml:"buildNumber"`
+		} `xml:"snapshot"`
+		SnapshotVersions []struct {
+			Classifier string `xml:"classifier"`
+			Extension  string `xml:"extension"`
+			Value      string `xml:"value"`
+		} `xml:"snapshotVersions>snapshotVersion"`
+	} `xml:"versioning"`
+}
+
+// snapshotVersion returns the version in the file names of the latest snapshot with the extension.
+func (md *mavenMetadata) snapshotVersion(version, extension string) string {
+	for _, v := range md.Versioning.SnapshotVersions {
+		if v.Extension == extension && v.Classifier == "" {
+			return v.Value
+		}
+	}
+	// Older repositories only record the timestamp and build number of the snapshot.
+	if s := md.Versioning.Snapshot; s.Timestamp != "" {
+		return fmt.Sprintf("%v-%v-%v", strings.TrimSuffix(version, "-SNAPSHOT"), s.Timestamp, s.BuildNumber)
+	}
+	return version
+}
+
+func getMavenMetadata(ctx context.Context, config *Config, addr string, headers map[string]string) (*mavenMetadata, error) {
+	b, err := repositoryRead(ctx, config, addr, headers)
+	if err != nil {
+		return nil, err
+	}
+	md := new(mavenMetadata)
+	if err := xml.Unmarshal(b, md); err != nil {
+		return nil, fmt.Errorf("could not unmarshal %v: %v", addr, err)
+	}
+	return md, nil
+}
+
+// mavenArtifactDefaults returns the values of name, version, jar_url and json_config_url implied by
+// the coordinates or the jar_url of the artifact. The versions of coordinates are resolved from the
+// metadata of the repository.
+func mavenArtifactDefaults(ctx context.Context, config *Config, d resourceGetter) (map[string]string, error) {
+	headers := toStringMap(d.Get("headers").(map[string]interface{}))
+	if s := d.Get("coordinates").(string); s != "" {
+		c, err := parseMavenCoordinates(s)
+		if err != nil {
+			return nil, err
+		}
+		dir := urlJoin(d.Get("repository_url").(string), strings.ReplaceAll(c.groupID, ".", "/"), c.artifactID)
+
+		version := c.version
+		if version == "RELEASE" || version == "LATEST" {
+			md, err := getMavenMetadata(ctx, config, urlJoin(dir, "maven-metadata.xml"), headers)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve %v: %v", s, err)
+			}
+			version = md.Versioning.Release
+			if c.version == "LATEST" {
+				version = md.Versioning.Latest
+			}
+			if version == "" {
+				return nil, fmt.Errorf("failed to resolve %v: the metadata of the repository has no %v version", s, strings.ToLower(c.version))
+			}
+		}
+
+		jarVersion, configVersion := version, version
+		if strings.HasSuffix(version, "-SNAPSHOT") {
+			md, err := getMavenMetadata(ctx, config, urlJoin(dir, version, "maven-metadata.xml"), headers)
+			// Repositories without unique snapshot versions have no metadata for them.
+			if err != nil && !isNotFound(err) {
+				return nil, fmt.Errorf("failed to resolve %v: %v", s, err)
+			}
+			if err == nil {
+				jarVersion, configVersion = md.snapshotVersion(version, "jar"), md.snapshotVersion(version, "json")
+			}
+		}
+
+		return map[string]string{
+			"name":            c.artifactID,
+			"version":         version,
+			"jar_url":         urlJoin(dir, version, c.artifactID+"-"+jarVersion+".jar"),
+			"json_config_url": urlJoin(dir, version, c.artifactID+"-"+configVersion+".json"),
+		}, nil
+	}
+
+	defaults := make(map[string]string)
+	u, err := url.Parse(d.Get("jar_url").(string))
+	if err != nil {
+		return nil, fmt.Errorf("invalid jar_url: %v", err)
+	}
+	if m := jarFileNameRE.FindStringSubmatch(path.Base(u.Path)); m != nil {
+		defaults["name"], defaults["version"] = m[1], m[2]
+	}
+	return defaults, nil
+}
+
+// resourceMavenArtifactCustomizeDiff resolves the coordinates during plan, so that the artifact is
+// replaced when a new snapshot or release is published, and plans the changes of its JSON config.
+func resourceMavenArtifactCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	config := m.(*Config)
+	raw := d.GetRawConfig()
+	if !raw.GetAttr("jar_url").IsNull() && raw.GetAttr("json_config_url").IsNull() {
+		return fmt.Errorf("json_config_url is required with jar_url")
+	}
+
+	keys := []string{"name", "version", "jar_url", "json_config_url"}
+	for _, k := range []string{"coordinates", "repository_url", "jar_url", "headers"} {
+		if !d.NewValueKnown(k) {
+			// Values that are not set are resolved on create instead.
+			for _, k := range keys {
+				if raw.GetAttr(k).IsNull() {
+					if err := d.SetNewComputed(k); err != nil {
+						return err
+					}
+				}
+			}
+			return setNewComputed(d, "jar_checksum", "properties", "parents")
+		}
+	}
+
+	defaults, err := mavenArtifactDefaults(ctx, config, d)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if !raw.GetAttr(k).IsNull() {
+			continue
+		}
+		v, ok := defaults[k]
+		if !ok {
+			return fmt.Errorf("%v is not set and could not be inferred from the file name of jar_url", k)
+		}
+		if d.Get(k).(string) != v {
+			if err := d.SetNew(k, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	headers := toStringMap(d.Get("headers").(map[string]interface{}))
+	checksum, err := repositoryChecksum(ctx, config, d.Get("jar_url").(string), headers)
+	if err != nil {
+		return err
+	}
+	if checksum == "" && d.Get("coordinates").(string) != "" {
+		return fmt.Errorf("no .sha256 or .sha1 checksum found for %v", d.Get("jar_url"))
+	}
+	old, _ := d.GetChange("jar_checksum")
+	switch {
+	case checksum != "" && checksum != old.(string):
+		if err := d.SetNew("jar_checksum", checksum); err != nil {
+			return err
+		}
+		if d.Id() != "" {
+			if err := d.ForceNew("jar_checksum"); err != nil {
+				return err
+			}
+		}
+	case checksum == "" && (d.Id() == "" || d.HasChange("jar_url")):
+		// Without a published checksum, the JAR is hashed once it is downloaded and a new URL is
+		// assumed to point to new content.
+		if err := d.SetNewComputed("jar_checksum"); err != nil {
+			return err
+		}
+		if d.Id() != "" {
+			if err := d.ForceNew("jar_url"); err != nil {
+				return err
+			}
+		}
+	}
+
+	loadConfig := func(ctx context.Context, config *Config, addr string) (*artifactConfig, error) {
+		b, err := repositoryRead(ctx, config, addr, headers)
+		if err != nil {
+			return nil, err
+		}
+		return parseArtifactConfig(b)
+	}
+	return customizeDiffArtifactConfig("json_config_url", loadConfig)(ctx, d, m)
+}
+
+func resourceMavenArtifactCreate(d *schema.ResourceData, m interface{}) error {
+	ctx := context.Background()
+	config := m.(*Config)
+	headers := toStringMap(d.Get("headers").(map[string]interface{}))
+
+	// Values that were unknown during plan are resolved now.
+	var defaults map[string]string
+	for _, k := range []string{"name", "version", "jar_url", "json_config_url"} {
+		if d.Get(k).(string) != "" {
+			continue
+		}
+		if defaults == nil {
+			var err error
+			if defaults, err = mavenArtifactDefaults(ctx, config, d); err != nil {
+				return err
+			}
+		}
+		v, ok := defaults[k]
+		if !ok {
+			return fmt.Errorf("%v is not set and could not be inferred from the file name of jar_url", k)
+		}
+		d.Set(k, v)
+	}
+
+	jarURL, configURL := d.Get("jar_url").(string), d.Get("json_config_url").(string)
+	checksum := d.Get("jar_checksum").(string)
+	if checksum == "" {
+		var err error
+		if checksum, err = repositoryChecksum(ctx, config, jarURL, headers); err != nil {
+			return err
+		}
+		if checksum == "" && d.Get("coordinates").(string) != "" {
+			return fmt.Errorf("no .sha256 or .sha1 checksum found for %v", jarURL)
+		}
+	}
+	jar, err := downloadToTempFile(ctx, config, jarURL, headers, checksum)
+	if err != nil {
+		return err
+	}
+	defer jar.Close()
+	manifest, err := jarManifest(io.NewSectionReader(jar, 0, jar.size))
+	if err != nil {
+		return fmt.Errorf("failed to read manifest of %v: %v", jarURL, err)
+	}
+	if v := manifest["Bundle-Version"]; v != "" && v != d.Get("version").(string) {
+		return fmt.Errorf("version %q does not match Bundle-Version %q of the manifest of %v", d.Get("version"), v, jarURL)
+	}
+
+	b, err := repositoryRead(ctx, config, configURL, headers)
+	if err != nil {
+		return fmt.Errorf("failed to download %v: %v", configURL, err)
+	}
+	configChecksum, err := repositoryChecksum(ctx, config, configURL, headers)
+	if err != nil {
+		return err
+	}
+	if configChecksum != "" {
+		if err := verifyChecksum(configURL, bytes.NewReader(b), configChecksum); err != nil {
+			return err
+		}
+	}
+	conf, err := parseArtifactConfig(b)
+	if err != nil {
+		return err
+	}
+
+	a := &artifact{
+		name:    d.Get("name").(string),
+		version: d.Get("version").(string),
+		config:  conf,
+		jar:     jar,
+		jarSize: jar.size,
+	}
+	if err := uploadArtifact(config, d, a); err != nil {
+		return err
+	}
+	d.Set("jar_checksum", jar.checksum)
+	return resourceMavenArtifactRead(d, m)
+}
+
+func resourceMavenArtifactRead(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	return readArtifact(context.Background(), config, d, nil, nil)
+}
+
+func resourceMavenArtifactUpdate(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	if err := updateArtifactProperties(config, d); err != nil {
+		return err
+	}
+	return resourceMavenArtifactRead(d, m)
+}
+
+// repositoryGet sends a GET request with the headers of the artifact to addr. Unlike httpCall, it
+// only logs the URL and never the headers, as they usually hold credentials.
+func repositoryGet(ctx context.Context, config *Config, addr string, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	if config.userAgent != "" {
+		req.Header.Set("User-Agent", config.userAgent)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	log.Printf("GET %v", addr)
+
+	resp, err := config.repositoryClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &httpError{code: resp.StatusCode, body: string(b)}
+	}
+	return resp, nil
+}
+
+func repositoryRead(ctx context.Context, config *Config, addr string, headers map[string]string) ([]byte, error) {
+	resp, err := repositoryGet(ctx, config, addr, headers)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// checksumAlgorithms are the checksum files looked up next to a file, from strongest to weakest.
+var checksumAlgorithms = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"sha256", sha256.New},
+	{"sha1", sha1.New},
+}
+
+// repositoryChecksum returns the checksum published next to the file at addr as algorithm:hex, or
+// an empty string if there is none.
+func repositoryChecksum(ctx context.Context, config *Config, addr string, headers map[string]string) (string, error) {
+	for _, a := range checksumAlgorithms {
+		b, err := repositoryRead(ctx, config, addr+"."+a.name, headers)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to get %v checksum of %v: %v", a.name, addr, err)
+		}
+		// Some tools write the file name after the checksum.
+		fields := strings.Fields(string(b))
+		if len(fields) == 0 {
+			return "", fmt.Errorf("empty %v checksum of %v", a.name, addr)
+		}
+		return a.name + ":" + strings.ToLower(fields[0]), nil
+	}
+	return "", nil
+}
+
+// newChecksumHash returns the hash of the algorithm of checksum, or SHA-256 if checksum is empty.
+func newChecksumHash(checksum string) (string, hash.Hash, error) {
+	if checksum == "" {
+		return "sha256", sha256.New(), nil
+	}
+	name := strings.SplitN(checksum, ":", 2)[0]
+	for _, a := range checksumAlgorithms {
+		if a.name == name {
+			return a.name, a.new(), nil
+		}
+	}
+	return "", nil, fmt.Errorf("unsupported checksum algorithm %q", name)
+}
+
+func verifyChecksum(addr string, r io.Reader, checksum string) error {
+	name, h, err := newChecksumHash(checksum)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	if got := name + ":" + hex.EncodeToString(h.Sum(nil)); got != checksum {
+		return fmt.Errorf("checksum mismatch for %v: got %v, want %v", addr, got, checksum)
+	}
+	return nil
+}
+
+// tempFile is a downloaded file that is removed when it is closed.
+type tempFile struct {
+	*os.File
+	size     int64
+	checksum string
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// downloadToTempFile downloads addr to a temporary file, so that large JARs are not held in memory,
+// and verifies it against checksum if it is set. The returned file records the checksum of its
+// content, which uses SHA-256 if no checksum was given.
+func downloadToTempFile(ctx context.Context, config *Config, addr string, headers map[string]string, checksum string) (*tempFile, error) {
+	name, h, err := newChecksumHash(checksum)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := repositoryGet(ctx, config, addr, headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %v: %v", addr, err)
+	}
+	defer resp.Body.Close()
+
+	f, err := ioutil.TempFile("", "cdap-artifact-*.jar")
+	if err != nil {
+		return nil, err
+	}
+	tf := &tempFile{File: f}
+	tf.size, err = io.Copy(io.MultiWriter(f, h), resp.Body)
+	if err != nil {
+		tf.Close()
+		return nil, fmt.Errorf("failed to download %v: %v", addr, err)
+	}
+	tf.checksum = name + ":" + hex.EncodeToString(h.Sum(nil))
+	if checksum != "" && tf.checksum != checksum {
+		tf.Close()
+		return nil, fmt.Errorf("checksum mismatch for %v: got %v, want %v", addr, tf.checksum, checksum)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		tf.Close()
+		return nil, err
+	}
+	return tf, nil
+}
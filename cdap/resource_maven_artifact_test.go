@@ -0,0 +1,297 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdap
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// fakeRepository serves files from a map of paths to contents and records the requests it received.
+type fakeRepository struct {
+	files    map[string]string
+	requests []*http.Request
+}
+
+func (f *fakeRepository) start(t *testing.T) (*Config, string) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.requests = append(f.requests, r)
+		b, ok := f.files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(b))
+	}))
+	t.Cleanup(srv.Close)
+	return &Config{repositoryClient: srv.Client()}, srv.URL
+}
+
+func sha256Hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func sha1Hex(s string) string {
+	h := sha1.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func mavenArtifactData(t *testing.T, raw map[string]interface{}) *schema.ResourceData {
+	t.Helper()
+	return schema.TestResourceDataRaw(t, resourceMavenArtifact().Schema, raw)
+}
+
+func TestMavenArtifactDefaults(t *testing.T) {
+	const dir = "/maven2/io/example/my-plugin"
+	files := map[string]string{
+		dir + "/maven-metadata.xml": `<metadata><versioning>
+			<latest>1.3.0-SNAPSHOT</latest>
+			<release>1.2.0</release>
+		</versioning></metadata>`,
+		dir + "/1.3.0-SNAPSHOT/maven-metadata.xml": `<metadata><versioning>
+			<snapshot><timestamp>20200101.120000</timestamp><buildNumber>3</buildNumber></snapshot>
+			<snapshotVersions>
+				<snapshotVersion><classifier>sources</classifier><extension>jar</extension><value>1.3.0-20200101.120000-2</value></snapshotVersion>
+				<snapshotVersion><extension>jar</extension><value>1.3.0-20200101.120000-3</value></snapshotVersion>
+				<snapshotVersion><extension>json</extension><value>1.3.0-20200101.110000-1</value></snapshotVersion>
+			</snapshotVersions>
+		</versioning></metadata>`,
+		dir + "/1.4.0-SNAPSHOT/maven-metadata.xml": `<metadata><versioning>
+			<snapshot><timestamp>20200202.120000</timestamp><buildNumber>7</buildNumber></snapshot>
+		</versioning></metadata>`,
+	}
+
+	tests := []struct {
+		coordinates string
+		want        map[string]string
+	}{
+		{
+			coordinates: "io.example:my-plugin:1.1.0",
+			want: map[string]string{
+				"name":            "my-plugin",
+				"version":         "1.1.0",
+				"jar_url":         dir + "/1.1.0/my-plugin-1.1.0.jar",
+				"json_config_url": dir + "/1.1.0/my-plugin-1.1.0.json",
+			},
+		},
+		{
+			coordinates: "io.example:my-plugin:RELEASE",
+			want: map[string]string{
+				"name":            "my-plugin",
+				"version":         "1.2.0",
+				"jar_url":         dir + "/1.2.0/my-plugin-1.2.0.jar",
+				"json_config_url": dir + "/1.2.0/my-plugin-1.2.0.json",
+			},
+		},
+		{
+			// LATEST resolves to a snapshot, which in turn resolves to the files of its latest build.
+			coordinates: "io.example:my-plugin:LATEST",
+			want: map[string]string{
+				"name":            "my-plugin",
+				"version":         "1.3.0-SNAPSHOT",
+				"jar_url":         dir + "/1.3.0-SNAPSHOT/my-plugin-1.3.0-20200101.120000-3.jar",
+				"json_config_url": dir + "/1.3.0-SNAPSHOT/my-plugin-1.3.0-20200101.110000-1.json",
+			},
+		},
+		{
+			coordinates: "io.example:my-plugin:1.4.0-SNAPSHOT",
+			want: map[string]string{
+				"name":            "my-plugin",
+				"version":         "1.4.0-SNAPSHOT",
+				"jar_url":         dir + "/1.4.0-SNAPSHOT/my-plugin-1.4.0-20200202.120000-7.jar",
+				"json_config_url": dir + "/1.4.0-SNAPSHOT/my-plugin-1.4.0-20200202.120000-7.json",
+			},
+		},
+		{
+			// Without metadata, the snapshot is not unique and its files use the version as is.
+			coordinates: "io.example:my-plugin:1.5.0-SNAPSHOT",
+			want: map[string]string{
+				"name":            "my-plugin",
+				"version":         "1.5.0-SNAPSHOT",
+				"jar_url":         dir + "/1.5.0-SNAPSHOT/my-plugin-1.5.0-SNAPSHOT.jar",
+				"json_config_url": dir + "/1.5.0-SNAPSHOT/my-plugin-1.5.0-SNAPSHOT.json",
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.coordinates, func(t *testing.T) {
+			config, addr := (&fakeRepository{files: files}).start(t)
+			d := mavenArtifactData(t, map[string]interface{}{
+				"repository_url": addr + "/maven2",
+				"coordinates":    tc.coordinates,
+			})
+			got, err := mavenArtifactDefaults(context.Background(), config, d)
+			if err != nil {
+				t.Fatalf("mavenArtifactDefaults() failed: %v", err)
+			}
+			for _, k := range []string{"jar_url", "json_config_url"} {
+				got[k] = strings.TrimPrefix(got[k], addr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMavenArtifactDefaultsNoRelease(t *testing.T) {
+	config, addr := (&fakeRepository{files: map[string]string{
+		"/io/example/my-plugin/maven-metadata.xml": `<metadata><versioning><latest>1.0.0-SNAPSHOT</latest></versioning></metadata>`,
+	}}).start(t)
+	d := mavenArtifactData(t, map[string]interface{}{
+		"repository_url": addr,
+		"coordinates":    "io.example:my-plugin:RELEASE",
+	})
+	if _, err := mavenArtifactDefaults(context.Background(), config, d); err == nil || !strings.Contains(err.Error(), "no release version") {
+		t.Errorf("got error %v, want no release version", err)
+	}
+}
+
+func TestMavenArtifactDefaultsHeaders(t *testing.T) {
+	repo := &fakeRepository{files: map[string]string{
+		"/io/example/my-plugin/maven-metadata.xml": `<metadata><versioning><release>1.0.0</release></versioning></metadata>`,
+	}}
+	config, addr := repo.start(t)
+	config.userAgent = "terraform-provider-cdap/test"
+	d := mavenArtifactData(t, map[string]interface{}{
+		"repository_url": addr,
+		"coordinates":    "io.example:my-plugin:RELEASE",
+		"headers":        map[string]interface{}{"Authorization": "Bearer secret", "X-Custom": "v"},
+	})
+	if _, err := mavenArtifactDefaults(context.Background(), config, d); err != nil {
+		t.Fatalf("mavenArtifactDefaults() failed: %v", err)
+	}
+	if len(repo.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(repo.requests))
+	}
+	h := repo.requests[0].Header
+	for k, want := range map[string]string{"Authorization": "Bearer secret", "X-Custom": "v", "User-Agent": "terraform-provider-cdap/test"} {
+		if got := h.Get(k); got != want {
+			t.Errorf("got header %v %q, want %q", k, got, want)
+		}
+	}
+}
+
+func TestRepositoryChecksum(t *testing.T) {
+	const jar = "jar content"
+	tests := []struct {
+		desc  string
+		files map[string]string
+		want  string
+	}{
+		{
+			desc: "sha256 preferred",
+			files: map[string]string{
+				"/a.jar.sha256": sha256Hex(jar) + "  a.jar\n",
+				"/a.jar.sha1":   sha1Hex(jar),
+			},
+			want: "sha256:" + sha256Hex(jar),
+		},
+		{
+			desc:  "sha1 if no sha256",
+			files: map[string]string{"/a.jar.sha1": strings.ToUpper(sha1Hex(jar)) + "\n"},
+			want:  "sha1:" + sha1Hex(jar),
+		},
+		{
+			desc:  "none",
+			files: map[string]string{},
+			want:  "",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			config, addr := (&fakeRepository{files: tc.files}).start(t)
+			got, err := repositoryChecksum(context.Background(), config, addr+"/a.jar", nil)
+			if err != nil {
+				t.Fatalf("repositoryChecksum() failed: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRepositoryChecksumError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "denied", http.StatusForbidden)
+	}))
+	defer srv.Close()
+	config := &Config{repositoryClient: srv.Client()}
+	if _, err := repositoryChecksum(context.Background(), config, srv.URL+"/a.jar", nil); err == nil {
+		t.Error("got no error for a forbidden checksum")
+	}
+}
+
+func TestDownloadToTempFile(t *testing.T) {
+	const jar = "jar content"
+	config, addr := (&fakeRepository{files: map[string]string{"/a.jar": jar}}).start(t)
+
+	for _, checksum := range []string{"", "sha256:" + sha256Hex(jar), "sha1:" + sha1Hex(jar)} {
+		f, err := downloadToTempFile(context.Background(), config, addr+"/a.jar", nil, checksum)
+		if err != nil {
+			t.Fatalf("downloadToTempFile(%q) failed: %v", checksum, err)
+		}
+		b, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != jar || f.size != int64(len(jar)) {
+			t.Errorf("got content %q of size %d, want %q", b, f.size, jar)
+		}
+		want := checksum
+		if want == "" {
+			want = "sha256:" + sha256Hex(jar)
+		}
+		if f.checksum != want {
+			t.Errorf("got checksum %q, want %q", f.checksum, want)
+		}
+	}
+}
+
+func TestDownloadToTempFileMismatch(t *testing.T) {
+	config, addr := (&fakeRepository{files: map[string]string{"/a.jar": "jar content"}}).start(t)
+	_, err := downloadToTempFile(context.Background(), config, addr+"/a.jar", nil, "sha256:"+sha256Hex("other content"))
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("got error %v, want checksum mismatch", err)
+	}
+	if _, err := downloadToTempFile(context.Background(), config, addr+"/a.jar", nil, "md5:abc"); err == nil {
+		t.Error("got no error for an unsupported checksum algorithm")
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	const content = `{"parents": []}`
+	if err := verifyChecksum("a.json", strings.NewReader(content), "sha1:"+sha1Hex(content)); err != nil {
+		t.Errorf("verifyChecksum() failed: %v", err)
+	}
+	err := verifyChecksum("a.json", strings.NewReader(content), "sha256:"+sha256Hex("other"))
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch for a.json") {
+		t.Errorf("got error %v, want checksum mismatch", err)
+	}
+}
@@ -0,0 +1,536 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/structure"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+const (
+	batchPipelineArtifact     = "cdap-data-pipeline"
+	streamingPipelineArtifact = "cdap-data-streams"
+)
+
+// resourcePipeline assembles an ETL pipeline spec from typed stages and
+// deploys it the same way as cdap_application.
+// https://docs.cdap.io/cdap/current/en/reference-manual/http-restful-api/lifecycle.html.
+func resourcePipeline() *schema.Resource {
+	return &schema.Resource{
+		Create: resourcePipelineCreate,
+		Read:   resourcePipelineRead,
+		Delete: resourcePipelineDelete,
+		Exists: resourcePipelineExists,
+
+		CustomizeDiff: resourcePipelineCustomizeDiff,
+
+		Schema: map[string]*schema.Schema{
+			"namespace": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "The name of the namespace in which this resource belongs. If not provided, the default namespace is used.",
+				DefaultFunc: func() (interface{}, error) {
+					return defaultNamespace, nil
+				},
+			},
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "The name of the pipeline. This will be used as the application name in the CDAP API.",
+			},
+			"description": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "A description of the pipeline.",
+			},
+			"artifact_name": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				Default:      batchPipelineArtifact,
+				Description:  "The pipeline artifact. One of cdap-data-pipeline (batch) or cdap-data-streams (realtime).",
+				ValidateFunc: validation.StringInSlice([]string{batchPipelineArtifact, streamingPipelineArtifact}, false),
+			},
+			"artifact_version": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "The version of the pipeline artifact, usually the CDAP version.",
+			},
+			"artifact_scope": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				Default:      "SYSTEM",
+				Description:  "The scope of the pipeline artifact. One of SYSTEM or USER.",
+				ValidateFunc: validation.StringInSlice([]string{"SYSTEM", "USER"}, false),
+			},
+			"engine": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				Description:  "The execution engine of a batch pipeline. One of spark or mapreduce. Defaults to spark.",
+				ValidateFunc: validation.StringInSlice([]string{"spark", "mapreduce"}, false),
+			},
+			"batch_interval": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "The batch interval of a realtime pipeline, e.g. 10s. Defaults to 10s.",
+			},
+			"resources":        pipelineResourcesSchema("The resources of the executors."),
+			"driver_resources": pipelineResourcesSchema("The resources of the driver."),
+			"properties": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "Additional pipeline properties, such as engine configuration.",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"stage": {
+				Type:        schema.TypeList,
+				Required:    true,
+				ForceNew:    true,
+				MinItems:    1,
+				Description: "The stages of the pipeline.",
+				Elem: &schema.Resource{
+					Schema: pipelinePluginSchema(map[string]*schema.Schema{
+						"input_schema": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ForceNew:     true,
+							Description:  "The JSON schema of the records entering the stage from each incoming stage_connection. Source stages have no input schema.",
+							ValidateFunc: validation.StringIsJSON,
+						},
+						"output_schema": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ForceNew:     true,
+							Description:  "The JSON schema of the records leaving the stage.",
+							ValidateFunc: validation.StringIsJSON,
+						},
+					}),
+				},
+			},
+			"stage_connection": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "The connections between stages.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"from": {
+							Type:        schema.TypeString,
+							Required:    true,
+							ForceNew:    true,
+							Description: "The name of the upstream stage.",
+						},
+						"to": {
+							Type:        schema.TypeString,
+							Required:    true,
+							ForceNew:    true,
+							Description: "The name of the downstream stage.",
+						},
+						"port": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							ForceNew:    true,
+							Description: "The output port of the upstream stage, for splitter stages.",
+						},
+						"condition": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ForceNew:     true,
+							Description:  "The branch of the upstream condition stage. One of true or false.",
+							ValidateFunc: validation.StringInSlice([]string{"true", "false"}, false),
+						},
+					},
+				},
+			},
+			"post_action": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "The actions to run after the pipeline finishes.",
+				Elem: &schema.Resource{
+					Schema: pipelinePluginSchema(nil),
+				},
+			},
+			"spec": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The assembled pipeline JSON spec that was deployed.",
+			},
+		},
+	}
+}
+
+// pipelinePluginSchema returns the schema shared by stages and post actions.
+func pipelinePluginSchema(extra map[string]*schema.Schema) map[string]*schema.Schema {
+	s := map[string]*schema.Schema{
+		"name": {
+			Type:        schema.TypeString,
+			Required:    true,
+			ForceNew:    true,
+			Description: "The unique name of the stage.",
+		},
+		"plugin_name": {
+			Type:        schema.TypeString,
+			Required:    true,
+			ForceNew:    true,
+			Description: "The name of the plugin, e.g. GCSFile.",
+		},
+		"plugin_type": {
+			Type:        schema.TypeString,
+			Required:    true,
+			ForceNew:    true,
+			Description: "The type of the plugin, e.g. batchsource, transform or postaction.",
+		},
+		"label": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			ForceNew:    true,
+			Description: "The label of the plugin. If not provided, the stage name is used.",
+		},
+		"artifact": {
+			Type:        schema.TypeList,
+			Required:    true,
+			ForceNew:    true,
+			MaxItems:    1,
+			Description: "The artifact providing the plugin.",
+			Elem: &schema.Resource{
+				Schema: map[string]*schema.Schema{
+					"name": {
+						Type:        schema.TypeString,
+						Required:    true,
+						ForceNew:    true,
+						Description: "The name of the artifact.",
+					},
+					"version": {
+						Type:        schema.TypeString,
+						Required:    true,
+						ForceNew:    true,
+						Description: "The version of the artifact.",
+					},
+					"scope": {
+						Type:         schema.TypeString,
+						Optional:     true,
+						ForceNew:     true,
+						Default:      "SYSTEM",
+						Description:  "The scope of the artifact. One of SYSTEM or USER.",
+						ValidateFunc: validation.StringInSlice([]string{"SYSTEM", "USER"}, false),
+					},
+				},
+			},
+		},
+		"properties": {
+			Type:        schema.TypeMap,
+			Optional:    true,
+			ForceNew:    true,
+			Description: "The properties of the plugin. Values may contain macros.",
+			Elem:        &schema.Schema{Type: schema.TypeString},
+		},
+	}
+	for k, v := range extra {
+		s[k] = v
+	}
+	return s
+}
+
+func pipelineResourcesSchema(description string) *schema.Schema {
+	return &schema.Schema{
+		Type:        schema.TypeList,
+		Optional:    true,
+		ForceNew:    true,
+		MaxItems:    1,
+		Description: description,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"memory_mb": {
+					Type:        schema.TypeInt,
+					Optional:    true,
+					ForceNew:    true,
+					Default:     2048,
+					Description: "The memory in megabytes.",
+				},
+				"virtual_cores": {
+					Type:        schema.TypeInt,
+					Optional:    true,
+					ForceNew:    true,
+					Default:     1,
+					Description: "The number of virtual cores.",
+				},
+			},
+		},
+	}
+}
+
+type pipelineSpec struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Artifact    *pluginArtifact `json:"artifact"`
+	Config      *pipelineConfig `json:"config"`
+}
+
+type pipelineConfig struct {
+	Resources       *pipelineResources    `json:"resources,omitempty"`
+	DriverResources *pipelineResources    `json:"driverResources,omitempty"`
+	Connections     []*pipelineConnection `json:"connections"`
+	Stages          []*pipelineStage      `json:"stages"`
+	PostActions     []*pipelineStage      `json:"postActions,omitempty"`
+	Properties      map[string]string     `json:"properties,omitempty"`
+	Engine          string                `json:"engine,omitempty"`
+	BatchInterval   string                `json:"batchInterval,omitempty"`
+}
+
+type pipelineResources struct {
+	MemoryMB     int `json:"memoryMB"`
+	VirtualCores int `json:"virtualCores"`
+}
+
+type pipelineConnection struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Port      string `json:"port,omitempty"`
+	Condition *bool  `json:"condition,omitempty"`
+}
+
+type pipelineStage struct {
+	Name         string                 `json:"name"`
+	Plugin       *pipelinePlugin        `json:"plugin"`
+	InputSchema  []*pipelineStageSchema `json:"inputSchema,omitempty"`
+	OutputSchema string                 `json:"outputSchema,omitempty"`
+}
+
+type pipelineStageSchema struct {
+	Name   string `json:"name"`
+	Schema string `json:"schema"`
+}
+
+type pipelinePlugin struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	Label      string            `json:"label"`
+	Artifact   *pluginArtifact   `json:"artifact"`
+	Properties map[string]string `json:"properties"`
+}
+
+type pluginArtifact struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Scope   string `json:"scope"`
+}
+
+func resourcePipelineCreate(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	name := d.Get("name").(string)
+
+	spec, err := buildPipelineSpec(d)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+
+	if err := deployApplication(config, d.Get("namespace").(string), name, bytes.NewReader(b)); err != nil {
+		return err
+	}
+
+	d.SetId(name)
+	normalized, err := structure.NormalizeJsonString(string(b))
+	if err != nil {
+		return err
+	}
+	return d.Set("spec", normalized)
+}
+
+// The attributes that buildPipelineSpec validates.
+var pipelineSpecKeys = []string{"artifact_name", "engine", "batch_interval", "stage", "stage_connection", "post_action"}
+
+// resourcePipelineCustomizeDiff validates the spec during plan rather than only on create. It is
+// skipped while any of the validated attributes is unknown, e.g. a stage name from another resource.
+func resourcePipelineCustomizeDiff(_ context.Context, d *schema.ResourceDiff, _ interface{}) error {
+	if d.Id() != "" && !d.HasChanges(pipelineSpecKeys...) {
+		return nil
+	}
+	raw := d.GetRawConfig()
+	for _, k := range pipelineSpecKeys {
+		if !raw.GetAttr(k).IsWhollyKnown() {
+			return nil
+		}
+	}
+	_, err := buildPipelineSpec(d)
+	return err
+}
+
+func buildPipelineSpec(d resourceGetter) (*pipelineSpec, error) {
+	artifactName := d.Get("artifact_name").(string)
+	conf := &pipelineConfig{
+		Resources:       expandPipelineResources(d.Get("resources").([]interface{})),
+		DriverResources: expandPipelineResources(d.Get("driver_resources").([]interface{})),
+		Connections:     []*pipelineConnection{},
+		Properties:      toStringMap(d.Get("properties").(map[string]interface{})),
+	}
+
+	engine := d.Get("engine").(string)
+	batchInterval := d.Get("batch_interval").(string)
+	switch artifactName {
+	case batchPipelineArtifact:
+		if batchInterval != "" {
+			return nil, fmt.Errorf("batch_interval is only supported by %s pipelines", streamingPipelineArtifact)
+		}
+		if engine == "" {
+			engine = "spark"
+		}
+		conf.Engine = engine
+	case streamingPipelineArtifact:
+		if engine != "" {
+			return nil, fmt.Errorf("engine is only supported by %s pipelines", batchPipelineArtifact)
+		}
+		if batchInterval == "" {
+			batchInterval = "10s"
+		}
+		conf.BatchInterval = batchInterval
+	}
+
+	rawStages := make(map[string]map[string]interface{})
+	for _, raw := range d.Get("stage").([]interface{}) {
+		rawStage := raw.(map[string]interface{})
+		name := rawStage["name"].(string)
+		if _, ok := rawStages[name]; ok {
+			return nil, fmt.Errorf("duplicate stage name %q", name)
+		}
+		rawStages[name] = rawStage
+	}
+
+	// Incoming schemas are keyed by the upstream stage name, so they are filled in from the connections.
+	inputs := make(map[string][]*pipelineStageSchema)
+	for _, raw := range d.Get("stage_connection").([]interface{}) {
+		rawConn := raw.(map[string]interface{})
+		conn := &pipelineConnection{
+			From: rawConn["from"].(string),
+			To:   rawConn["to"].(string),
+			Port: rawConn["port"].(string),
+		}
+		for _, n := range []string{conn.From, conn.To} {
+			if _, ok := rawStages[n]; !ok {
+				return nil, fmt.Errorf("connection %s -> %s references unknown stage %q", conn.From, conn.To, n)
+			}
+		}
+		if c := rawConn["condition"].(string); c != "" {
+			b := c == "true"
+			conn.Condition = &b
+		}
+		conf.Connections = append(conf.Connections, conn)
+
+		if s := rawStages[conn.To]["input_schema"].(string); s != "" {
+			inputs[conn.To] = append(inputs[conn.To], &pipelineStageSchema{Name: conn.From, Schema: s})
+		}
+	}
+
+	for _, raw := range d.Get("stage").([]interface{}) {
+		rawStage := raw.(map[string]interface{})
+		stage := expandPipelineStage(rawStage)
+		if rawStage["input_schema"].(string) != "" && len(inputs[stage.Name]) == 0 {
+			return nil, fmt.Errorf("stage %q has an input_schema but no incoming stage_connection", stage.Name)
+		}
+		stage.InputSchema = inputs[stage.Name]
+		stage.OutputSchema = rawStage["output_schema"].(string)
+		conf.Stages = append(conf.Stages, stage)
+	}
+
+	for _, raw := range d.Get("post_action").([]interface{}) {
+		conf.PostActions = append(conf.PostActions, expandPipelineStage(raw.(map[string]interface{})))
+	}
+
+	return &pipelineSpec{
+		Name:        d.Get("name").(string),
+		Description: d.Get("description").(string),
+		Artifact: &pluginArtifact{
+			Name:    artifactName,
+			Version: d.Get("artifact_version").(string),
+			Scope:   d.Get("artifact_scope").(string),
+		},
+		Config: conf,
+	}, nil
+}
+
+func expandPipelineStage(raw map[string]interface{}) *pipelineStage {
+	name := raw["name"].(string)
+	label := raw["label"].(string)
+	if label == "" {
+		label = name
+	}
+	rawArtifact := raw["artifact"].([]interface{})[0].(map[string]interface{})
+	return &pipelineStage{
+		Name: name,
+		Plugin: &pipelinePlugin{
+			Name:  raw["plugin_name"].(string),
+			Type:  raw["plugin_type"].(string),
+			Label: label,
+			Artifact: &pluginArtifact{
+				Name:    rawArtifact["name"].(string),
+				Version: rawArtifact["version"].(string),
+				Scope:   rawArtifact["scope"].(string),
+			},
+			Properties: toStringMap(raw["properties"].(map[string]interface{})),
+		},
+	}
+}
+
+func expandPipelineResources(raw []interface{}) *pipelineResources {
+	if len(raw) == 0 || raw[0] == nil {
+		return nil
+	}
+	r := raw[0].(map[string]interface{})
+	return &pipelineResources{
+		MemoryMB:     r["memory_mb"].(int),
+		VirtualCores: r["virtual_cores"].(int),
+	}
+}
+
+// toStringMap casts a map[string]interface{} from a TypeMap of strings to a map[string]string.
+func toStringMap(raw map[string]interface{}) map[string]string {
+	res := make(map[string]string, len(raw))
+	for k, v := range raw {
+		res[k] = v.(string)
+	}
+	return res
+}
+
+func resourcePipelineRead(d *schema.ResourceData, m interface{}) error {
+	return nil
+}
+
+func resourcePipelineDelete(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	return deleteApplication(config, d.Get("namespace").(string), d.Get("name").(string))
+}
+
+func resourcePipelineExists(d *schema.ResourceData, m interface{}) (bool, error) {
+	config := m.(*Config)
+	return applicationExists(config, d.Get("namespace").(string), d.Get("name").(string))
+}
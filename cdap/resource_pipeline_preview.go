@@ -0,0 +1,336 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/structure"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// Statuses in which a preview is no longer running.
+var previewEndStatuses = map[string]bool{
+	"COMPLETED":                         true,
+	"DEPLOY_FAILED":                     true,
+	"RUN_FAILED":                        true,
+	"KILLED":                            true,
+	"KILLED_BY_TIMER":                   true,
+	"KILLED_BY_EXCEEDING_MEMORY_LIMITS": true,
+}
+
+// resourcePipelinePreview runs a pipeline spec in preview mode and fails if the preview fails.
+// https://docs.cdap.io/cdap/current/en/reference-manual/http-restful-api/preview.html
+func resourcePipelinePreview() *schema.Resource {
+	return &schema.Resource{
+		Create: resourcePipelinePreviewCreate,
+		Read:   resourcePipelinePreviewRead,
+		Delete: resourcePipelinePreviewDelete,
+
+		Schema: map[string]*schema.Schema{
+			"namespace": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "The name of the namespace in which this resource belongs. If not provided, the default namespace is used.",
+				DefaultFunc: func() (interface{}, error) {
+					return defaultNamespace, nil
+				},
+			},
+			"spec": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				Description:  "The full contents of the pipeline JSON spec to preview.",
+				ValidateFunc: validation.StringIsJSON,
+				StateFunc: func(v interface{}) string {
+					json, _ := structure.NormalizeJsonString(v)
+					return json
+				},
+			},
+			"runtime_arguments": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "The runtime arguments used to run the preview.",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"preview_timeout_minutes": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "The time after which CDAP stops the preview. Realtime previews always run until this timeout.",
+			},
+			"num_records": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "The number of records to read from each source.",
+			},
+			"preview_id": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The ID of the preview run.",
+			},
+			"status": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The final status of the preview.",
+			},
+			"stage": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "The record counts of each stage of the preview, from the metrics of the preview.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The name of the stage.",
+						},
+						"records_in": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "The number of records the stage received.",
+						},
+						"records_out": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "The number of records the stage emitted.",
+						},
+						"records_error": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "The number of records the stage emitted as errors.",
+						},
+					},
+				},
+			},
+		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(30 * time.Minute),
+		},
+	}
+}
+
+type previewConfig struct {
+	ProgramName string            `json:"programName"`
+	ProgramType string            `json:"programType"`
+	RuntimeArgs map[string]string `json:"runtimeArgs,omitempty"`
+	Timeout     int               `json:"timeout,omitempty"`
+}
+
+type previewStatus struct {
+	Status    string `json:"status"`
+	Throwable *struct {
+		Message string `json:"message"`
+	} `json:"throwable"`
+}
+
+func resourcePipelinePreviewCreate(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	addr := urlJoin(config.host, "/v3/namespaces", d.Get("namespace").(string), "/previews")
+
+	var spec map[string]interface{}
+	if err := json.Unmarshal([]byte(d.Get("spec").(string)), &spec); err != nil {
+		return err
+	}
+	conf, ok := spec["config"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("spec is missing the pipeline config")
+	}
+	artifactName := ""
+	if a, ok := spec["artifact"].(map[string]interface{}); ok {
+		artifactName, _ = a["name"].(string)
+	}
+	streaming := artifactName == streamingPipelineArtifact
+
+	preview := &previewConfig{
+		ProgramName: "DataPipelineWorkflow",
+		ProgramType: "WORKFLOW",
+		RuntimeArgs: toStringMap(d.Get("runtime_arguments").(map[string]interface{})),
+		Timeout:     d.Get("preview_timeout_minutes").(int),
+	}
+	if streaming {
+		preview.ProgramName = "DataStreamsSparkStreaming"
+		preview.ProgramType = "SPARK"
+	}
+	conf["preview"] = preview
+	if n := d.Get("num_records").(int); n > 0 {
+		conf["numOfRecordsPreview"] = n
+	}
+
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, addr, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	b, err = httpCall(config, req)
+	if err != nil {
+		return fmt.Errorf("failed to start preview: %v", err)
+	}
+
+	var appID struct {
+		Application string `json:"application"`
+	}
+	if err := json.Unmarshal(b, &appID); err != nil {
+		return fmt.Errorf("could not unmarshal preview id: %v", err)
+	}
+	previewAddr := urlJoin(addr, appID.Application)
+	d.Set("preview_id", appID.Application)
+
+	var status *previewStatus
+	err = resource.Retry(d.Timeout(schema.TimeoutCreate), func() *resource.RetryError {
+		time.Sleep(10 * time.Second)
+		status, err = getPreviewStatus(config, previewAddr)
+		if err != nil {
+			return resource.NonRetryableError(err)
+		}
+		if !previewEndStatuses[status.Status] {
+			return resource.RetryableError(fmt.Errorf("still waiting for preview %v which is in state: %v", appID.Application, status.Status))
+		}
+		return nil
+	})
+	if err != nil {
+		// Stop the preview rather than leaving it to run until its own timeout.
+		if status == nil || !previewEndStatuses[status.Status] {
+			if stopErr := stopPreview(config, previewAddr); stopErr != nil {
+				log.Printf("failed to stop preview %v: %v", appID.Application, stopErr)
+			}
+		}
+		return err
+	}
+
+	d.Set("status", status.Status)
+
+	// Realtime previews never complete on their own, so the timer ending them is expected.
+	if status.Status != "COMPLETED" && !(streaming && status.Status == "KILLED_BY_TIMER") {
+		msg := ""
+		if status.Throwable != nil {
+			msg = status.Throwable.Message
+		}
+		return fmt.Errorf("preview %v failed in state: %v: %v", appID.Application, status.Status, msg)
+	}
+
+	stages, err := getPreviewStageCounts(config, previewAddr, conf)
+	if err != nil {
+		return err
+	}
+	d.Set("stage", stages)
+
+	d.SetId(appID.Application)
+	return nil
+}
+
+func getPreviewStatus(config *Config, previewAddr string) (*previewStatus, error) {
+	req, err := http.NewRequest(http.MethodGet, urlJoin(previewAddr, "/status"), nil)
+	if err != nil {
+		return nil, err
+	}
+	b, err := httpCall(config, req)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get preview status: %v", err)
+	}
+	var s *previewStatus
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("could not unmarshal preview status: %v", err)
+	}
+	return s, nil
+}
+
+func stopPreview(config *Config, previewAddr string) error {
+	req, err := http.NewRequest(http.MethodPost, urlJoin(previewAddr, "/stop"), nil)
+	if err != nil {
+		return err
+	}
+	_, err = httpCall(config, req)
+	return err
+}
+
+// getPreviewStageCounts returns the number of records in and out of each stage from the metrics
+// of the preview. The traced records are not counted, as they are capped by the trace limit.
+func getPreviewStageCounts(config *Config, previewAddr string, conf map[string]interface{}) ([]map[string]interface{}, error) {
+	var names []string
+	stages, _ := conf["stages"].([]interface{})
+	for _, s := range stages {
+		if stage, ok := s.(map[string]interface{}); ok {
+			if name, ok := stage["name"].(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	res := []map[string]interface{}{}
+	if len(names) == 0 {
+		return res, nil
+	}
+
+	q := url.Values{}
+	for _, name := range names {
+		for _, suffix := range []string{"in", "out", "error"} {
+			q.Add("metric", "user."+name+".records."+suffix)
+		}
+	}
+	q.Set("aggregate", "true")
+	req, err := http.NewRequest(http.MethodPost, urlJoin(previewAddr, "/metrics/query")+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	b, err := httpCall(config, req)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get preview metrics: %v", err)
+	}
+	var metrics metricsQueryResult
+	if err := json.Unmarshal(b, &metrics); err != nil {
+		return nil, fmt.Errorf("could not unmarshal preview metrics: %v", err)
+	}
+
+	// Metrics that were never emitted, e.g. by a stage without errors, are missing from the result.
+	counts := make(map[string]int64)
+	for _, series := range metrics.Series {
+		for _, p := range series.Data {
+			counts[series.MetricName] += p.Value
+		}
+	}
+	for _, name := range names {
+		res = append(res, map[string]interface{}{
+			"name":          name,
+			"records_in":    counts["user."+name+".records.in"],
+			"records_out":   counts["user."+name+".records.out"],
+			"records_error": counts["user."+name+".records.error"],
+		})
+	}
+	return res, nil
+}
+
+func resourcePipelinePreviewRead(d *schema.ResourceData, m interface{}) error {
+	return nil
+}
+
+// Previews are cleaned up by CDAP, so deleting only removes the resource from state.
+func resourcePipelinePreviewDelete(d *schema.ResourceData, m interface{}) error {
+	d.SetId("")
+	return nil
+}
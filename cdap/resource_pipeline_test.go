@@ -0,0 +1,99 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdap
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	ctyjson "github.com/hashicorp/go-cty/cty/json"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// planPipeline plans the creation of a pipeline with the config, as Terraform does.
+func planPipeline(t *testing.T, raw map[string]interface{}) error {
+	t.Helper()
+	r := resourcePipeline()
+	b, err := json.Marshal(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := ctyjson.Unmarshal(b, r.CoreConfigSchema().ImpliedType())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Diff(context.Background(), &terraform.InstanceState{RawConfig: val}, terraform.NewResourceConfigRaw(raw), &Config{})
+	return err
+}
+
+func pipelineStageConfig(name, inputSchema string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":         name,
+		"plugin_name":  "Plugin",
+		"plugin_type":  "transform",
+		"input_schema": inputSchema,
+		"artifact":     []interface{}{map[string]interface{}{"name": "core-plugins", "version": "2.0.0", "scope": "SYSTEM"}},
+	}
+}
+
+func TestPipelinePlanValidatesSpec(t *testing.T) {
+	tests := []struct {
+		desc    string
+		stages  []interface{}
+		conns   []interface{}
+		wantErr string
+	}{
+		{
+			desc:   "valid",
+			stages: []interface{}{pipelineStageConfig("a", ""), pipelineStageConfig("b", `{"type":"record"}`)},
+			conns:  []interface{}{map[string]interface{}{"from": "a", "to": "b"}},
+		},
+		{
+			desc:    "input schema without connection",
+			stages:  []interface{}{pipelineStageConfig("a", `{"type":"record"}`)},
+			wantErr: `stage "a" has an input_schema but no incoming stage_connection`,
+		},
+		{
+			desc:    "duplicate stage",
+			stages:  []interface{}{pipelineStageConfig("a", ""), pipelineStageConfig("a", "")},
+			wantErr: `duplicate stage name "a"`,
+		},
+		{
+			desc:    "unknown stage",
+			stages:  []interface{}{pipelineStageConfig("a", "")},
+			conns:   []interface{}{map[string]interface{}{"from": "a", "to": "c"}},
+			wantErr: `references unknown stage "c"`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := planPipeline(t, map[string]interface{}{
+				"name":             "p",
+				"artifact_name":    batchPipelineArtifact,
+				"artifact_version": "6.0.0",
+				"stage":            tc.stages,
+				"stage_connection": tc.conns,
+			})
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("got error %v, want none", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("got error %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdap
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// This is the schedule property Studio uses to pass arguments and plugin properties of the upstream pipeline run.
+const triggeringPropertiesMappingKey = "triggering.properties.mapping"
+
+// resourcePipelineTrigger starts a pipeline when an upstream pipeline reaches one of the given
+// statuses, the same way as inbound triggers created in Studio.
+func resourcePipelineTrigger() *schema.Resource {
+	upstream := scheduleProgramStatusTriggerSchema()
+	delete(upstream, "statuses")
+
+	s := map[string]*schema.Schema{
+		"namespace": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			ForceNew:    true,
+			Description: "The name of the namespace in which this resource belongs. If not provided, the default namespace is used.",
+			DefaultFunc: func() (interface{}, error) {
+				return defaultNamespace, nil
+			},
+		},
+		"app": {
+			Type:        schema.TypeString,
+			Required:    true,
+			ForceNew:    true,
+			Description: "Name of the downstream pipeline to start.",
+		},
+		"name": {
+			Type:        schema.TypeString,
+			Required:    true,
+			ForceNew:    true,
+			Description: "The name of the trigger.",
+		},
+		"description": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "A description of the trigger.",
+		},
+		"upstream": {
+			Type:        schema.TypeList,
+			Required:    true,
+			MaxItems:    1,
+			Description: "The upstream pipeline whose runs fire the trigger.",
+			Elem:        &schema.Resource{Schema: upstream},
+		},
+		"statuses": {
+			Type:        schema.TypeSet,
+			Required:    true,
+			Description: "The statuses of the upstream pipeline that fire the trigger. Any of COMPLETED, FAILED or KILLED.",
+			Elem: &schema.Schema{
+				Type:         schema.TypeString,
+				ValidateFunc: validation.StringInSlice(scheduleProgramStatuses, false),
+			},
+		},
+		"argument_mapping": {
+			Type:        schema.TypeList,
+			Optional:    true,
+			Description: "Runtime arguments of the upstream run to pass to the downstream pipeline.",
+			Elem: &schema.Resource{
+				Schema: map[string]*schema.Schema{
+					"source": {
+						Type:        schema.TypeString,
+						Required:    true,
+						Description: "The name of the runtime argument of the upstream run.",
+					},
+					"target": {
+						Type:        schema.TypeString,
+						Required:    true,
+						Description: "The name of the runtime argument of the downstream pipeline.",
+					},
+				},
+			},
+		},
+		"plugin_property_mapping": {
+			Type:        schema.TypeList,
+			Optional:    true,
+			Description: "Plugin properties of the upstream pipeline to pass to the downstream pipeline as runtime arguments.",
+			Elem: &schema.Resource{
+				Schema: map[string]*schema.Schema{
+					"stage": {
+						Type:        schema.TypeString,
+						Required:    true,
+						Description: "The name of the stage of the upstream pipeline.",
+					},
+					"source": {
+						Type:        schema.TypeString,
+						Required:    true,
+						Description: "The name of the plugin property of the stage.",
+					},
+					"target": {
+						Type:        schema.TypeString,
+						Required:    true,
+						Description: "The name of the runtime argument of the downstream pipeline.",
+					},
+				},
+			},
+		},
+		"properties": {
+			Type:        schema.TypeMap,
+			Optional:    true,
+			Description: "Additional properties of the trigger, which are passed to the pipeline as runtime arguments.",
+			Elem:        &schema.Schema{Type: schema.TypeString},
+		},
+		"enabled": {
+			Type:        schema.TypeBool,
+			Optional:    true,
+			Default:     true,
+			Description: "Whether the trigger is enabled. Disabled triggers are suspended.",
+		},
+	}
+	for k, v := range scheduleConstraintsSchema() {
+		s[k] = v
+	}
+
+	return &schema.Resource{
+		Create: resourcePipelineTriggerCreate,
+		Read:   resourcePipelineTriggerRead,
+		Update: resourcePipelineTriggerUpdate,
+		Delete: resourceScheduleDelete,
+		Importer: &schema.ResourceImporter{
+			State: resourceScheduleImport,
+		},
+
+		Schema: s,
+	}
+}
+
+// triggeringPropertyMapping is stored as JSON in the triggering.properties.mapping property.
+type triggeringPropertyMapping struct {
+	Arguments        []*argumentMapping       `json:"arguments"`
+	PluginProperties []*pluginPropertyMapping `json:"pluginProperties"`
+}
+
+type triggeringPipelineID struct {
+	Namespace    string `json:"namespace"`
+	PipelineName string `json:"pipelineName"`
+}
+
+type argumentMapping struct {
+	Source     string                `json:"source"`
+	Target     string                `json:"target"`
+	PipelineID *triggeringPipelineID `json:"pipelineId,omitempty"`
+}
+
+type pluginPropertyMapping struct {
+	StageName  string                `json:"stageName"`
+	Source     string                `json:"source"`
+	Target     string                `json:"target"`
+	PipelineID *triggeringPipelineID `json:"pipelineId,omitempty"`
+}
+
+func resourcePipelineTriggerCreate(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	s, err := expandPipelineTrigger(d)
+	if err != nil {
+		return err
+	}
+
+	addr := getScheduleAddr(config, d)
+	if err := putSchedule(config, addr, s); err != nil {
+		return err
+	}
+	d.SetId(scheduleID(d))
+
+	if err := setScheduleEnabled(config, addr, d.Get("enabled").(bool)); err != nil {
+		return err
+	}
+	return resourcePipelineTriggerRead(d, m)
+}
+
+func resourcePipelineTriggerRead(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+
+	s, enabled, err := getSchedule(config, getScheduleAddr(config, d))
+	if isNotFound(err) {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if s.Trigger.Type != "PROGRAM_STATUS" {
+		return fmt.Errorf("schedule %q is not a pipeline trigger, it has a %v trigger", s.Name, s.Trigger.Type)
+	}
+
+	upstream := flattenProgramStatusTrigger(s.Trigger)
+	d.Set("statuses", upstream["statuses"])
+	delete(upstream, "statuses")
+	if err := d.Set("upstream", []interface{}{upstream}); err != nil {
+		return err
+	}
+
+	mapping := new(triggeringPropertyMapping)
+	if raw, ok := s.Properties[triggeringPropertiesMappingKey]; ok {
+		if err := json.Unmarshal([]byte(raw), mapping); err != nil {
+			return fmt.Errorf("could not unmarshal %v: %v", triggeringPropertiesMappingKey, err)
+		}
+		delete(s.Properties, triggeringPropertiesMappingKey)
+	}
+	var args []interface{}
+	for _, a := range mapping.Arguments {
+		args = append(args, map[string]interface{}{"source": a.Source, "target": a.Target})
+	}
+	var props []interface{}
+	for _, p := range mapping.PluginProperties {
+		props = append(props, map[string]interface{}{"stage": p.StageName, "source": p.Source, "target": p.Target})
+	}
+	if err := d.Set("argument_mapping", args); err != nil {
+		return err
+	}
+	if err := d.Set("plugin_property_mapping", props); err != nil {
+		return err
+	}
+
+	d.Set("description", s.Description)
+	d.Set("properties", s.Properties)
+	d.Set("enabled", enabled)
+	return flattenScheduleConstraints(d, s.Constraints)
+}
+
+func resourcePipelineTriggerUpdate(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	addr := getScheduleAddr(config, d)
+
+	if d.HasChangeExcept("enabled") {
+		s, err := expandPipelineTrigger(d)
+		if err != nil {
+			return err
+		}
+		if err := updateSchedule(config, addr, s); err != nil {
+			return err
+		}
+	}
+
+	if err := setScheduleEnabled(config, addr, d.Get("enabled").(bool)); err != nil {
+		return err
+	}
+	return resourcePipelineTriggerRead(d, m)
+}
+
+func expandPipelineTrigger(d *schema.ResourceData) (*schedule, error) {
+	namespace := d.Get("namespace").(string)
+
+	rawUpstream := d.Get("upstream").([]interface{})[0].(map[string]interface{})
+	rawUpstream["statuses"] = d.Get("statuses")
+	trigger := expandProgramStatusTrigger(namespace, rawUpstream)
+
+	upstreamID := &triggeringPipelineID{
+		Namespace:    trigger.ProgramID.Namespace,
+		PipelineName: trigger.ProgramID.Application,
+	}
+	mapping := &triggeringPropertyMapping{
+		Arguments:        []*argumentMapping{},
+		PluginProperties: []*pluginPropertyMapping{},
+	}
+	for _, raw := range d.Get("argument_mapping").([]interface{}) {
+		a := raw.(map[string]interface{})
+		mapping.Arguments = append(mapping.Arguments, &argumentMapping{
+			Source:     a["source"].(string),
+			Target:     a["target"].(string),
+			PipelineID: upstreamID,
+		})
+	}
+	for _, raw := range d.Get("plugin_property_mapping").([]interface{}) {
+		p := raw.(map[string]interface{})
+		mapping.PluginProperties = append(mapping.PluginProperties, &pluginPropertyMapping{
+			StageName:  p["stage"].(string),
+			Source:     p["source"].(string),
+			Target:     p["target"].(string),
+			PipelineID: upstreamID,
+		})
+	}
+	b, err := json.Marshal(mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	props := toStringMap(d.Get("properties").(map[string]interface{}))
+	if _, ok := props[triggeringPropertiesMappingKey]; ok {
+		return nil, fmt.Errorf("properties must not contain %v, use argument_mapping and plugin_property_mapping instead", triggeringPropertiesMappingKey)
+	}
+	props[triggeringPropertiesMappingKey] = string(b)
+
+	return &schedule{
+		Name:        d.Get("name").(string),
+		Description: d.Get("description").(string),
+		Program: &scheduleProgram{
+			ProgramName: "DataPipelineWorkflow",
+			ProgramType: "WORKFLOW",
+		},
+		Properties:  props,
+		Constraints: expandScheduleConstraints(d),
+		Trigger:     trigger,
+	}, nil
+}
@@ -0,0 +1,355 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// resourceProgram keeps a long-running program, i.e. a service or worker, in the desired state.
+// https://docs.cdap.io/cdap/current/en/reference-manual/http-restful-api/lifecycle.html
+func resourceProgram() *schema.Resource {
+	r := &schema.Resource{
+		Create: resourceProgramCreate,
+		Read:   resourceProgramRead,
+		Update: resourceProgramUpdate,
+		Delete: resourceProgramDelete,
+		Importer: &schema.ResourceImporter{
+			State: resourceProgramImport,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"namespace": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "The name of the namespace in which this resource belongs. If not provided, the default namespace is used.",
+				DefaultFunc: func() (interface{}, error) {
+					return defaultNamespace, nil
+				},
+			},
+			"app": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Name of the application.",
+			},
+			"program": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Name of the program.",
+			},
+			"type": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				Description:  "One of services or workers.",
+				ValidateFunc: validation.StringInSlice([]string{"services", "workers"}, false),
+			},
+			"state": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "RUNNING",
+				Description:  "The desired state of the program. One of RUNNING or STOPPED.",
+				ValidateFunc: validation.StringInSlice([]string{"RUNNING", "STOPPED"}, false),
+			},
+			"instances": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Computed:     true,
+				Description:  "The number of instances of the program. If not provided, the current number is kept.",
+				ValidateFunc: validation.IntAtLeast(1),
+			},
+			"runtime_arguments": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Description: "The runtime arguments used to start the program. Changing them restarts the program if it is running.",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"run_id": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The CDAP run ID of the active run, if any.",
+			},
+			"status": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The status of the program as reported by CDAP.",
+			},
+		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(20 * time.Minute),
+			Update: schema.DefaultTimeout(20 * time.Minute),
+			Delete: schema.DefaultTimeout(20 * time.Minute),
+		},
+	}
+	for k, v := range stopOptionsSchema() {
+		r.Schema[k] = v
+	}
+	return r
+}
+
+func resourceProgramCreate(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	addr := getProgramAddr(config, d)
+
+	if _, err := getProgramStatus(config, addr); err != nil {
+		return err
+	}
+	d.SetId(programResourceID(d))
+
+	if n, ok := d.GetOk("instances"); ok {
+		if err := setProgramInstances(config, addr, n.(int)); err != nil {
+			return err
+		}
+	}
+	if err := reconcileProgramState(config, d, d.Timeout(schema.TimeoutCreate)); err != nil {
+		return err
+	}
+	return resourceProgramRead(d, m)
+}
+
+func resourceProgramRead(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	addr := getProgramAddr(config, d)
+
+	status, err := getProgramStatus(config, addr)
+	if isNotFound(err) {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	d.Set("status", status)
+	if isProgramActive(status) {
+		d.Set("state", "RUNNING")
+	} else {
+		d.Set("state", "STOPPED")
+	}
+
+	runs, err := getActiveRuns(config, urlJoin(addr, "runs"))
+	if err != nil {
+		return err
+	}
+	runID := ""
+	if len(runs) > 0 {
+		runID = runs[0].RunID
+	}
+	d.Set("run_id", runID)
+
+	n, err := getProgramInstances(config, addr)
+	if err != nil {
+		return err
+	}
+	d.Set("instances", n)
+	return nil
+}
+
+func resourceProgramUpdate(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	addr := getProgramAddr(config, d)
+	timeout := d.Timeout(schema.TimeoutUpdate)
+
+	if d.HasChange("instances") {
+		if err := setProgramInstances(config, addr, d.Get("instances").(int)); err != nil {
+			return err
+		}
+	}
+
+	// New runtime arguments only take effect on start, so restart a program that stays running.
+	if d.HasChange("runtime_arguments") && d.Get("state").(string) == "RUNNING" {
+		if err := stopActiveRuns(config, d, timeout); err != nil {
+			return err
+		}
+	}
+	if err := reconcileProgramState(config, d, timeout); err != nil {
+		return err
+	}
+	return resourceProgramRead(d, m)
+}
+
+func resourceProgramDelete(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	return stopActiveRuns(config, d, d.Timeout(schema.TimeoutDelete))
+}
+
+func resourceProgramImport(d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	parts := strings.Split(d.Id(), "/")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("unexpected import id %q: want namespace/app/type/program", d.Id())
+	}
+	d.Set("namespace", parts[0])
+	d.Set("app", parts[1])
+	d.Set("type", parts[2])
+	d.Set("program", parts[3])
+	return []*schema.ResourceData{d}, nil
+}
+
+func programResourceID(d resourceGetter) string {
+	return strings.Join([]string{d.Get("namespace").(string), d.Get("app").(string), d.Get("type").(string), d.Get("program").(string)}, "/")
+}
+
+// reconcileProgramState starts or stops the program to match the desired state. Services are
+// only considered running once they are available to serve requests.
+func reconcileProgramState(config *Config, d *schema.ResourceData, timeout time.Duration) error {
+	addr := getProgramAddr(config, d)
+	status, err := getProgramStatus(config, addr)
+	if err != nil {
+		return err
+	}
+
+	if d.Get("state").(string) == "STOPPED" {
+		if isProgramActive(status) {
+			return stopActiveRuns(config, d, timeout)
+		}
+		return nil
+	}
+
+	if !isProgramActive(status) {
+		args := toStringMap(d.Get("runtime_arguments").(map[string]interface{}))
+		if _, err := startProgramAndWaitForRunning(config, d, args, timeout); err != nil {
+			return err
+		}
+	}
+	if d.Get("type").(string) == "services" {
+		return waitForServiceAvailable(config, addr, timeout)
+	}
+	return nil
+}
+
+func isProgramActive(status string) bool {
+	return status == "RUNNING" || programRunInitializingStatuses[status]
+}
+
+func getProgramStatus(config *Config, addr string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, urlJoin(addr, "/status"), nil)
+	if err != nil {
+		return "", err
+	}
+	b, err := httpCall(config, req)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get program status: %w", err)
+	}
+	var s programStatus
+	if err := json.Unmarshal(b, &s); err != nil {
+		return "", fmt.Errorf("could not unmarshal program status: %v", err)
+	}
+	return s.Status, nil
+}
+
+// getActiveRuns returns the runs of the program that have not ended yet.
+func getActiveRuns(config *Config, runsAddr string) ([]*run, error) {
+	runs, err := listRuns(config, runsAddr, &runFilter{limit: runsPageSize})
+	if err != nil {
+		return nil, err
+	}
+	var active []*run
+	for _, r := range runs {
+		if !programRunEndStatuses[r.Status] {
+			active = append(active, r)
+		}
+	}
+	return active, nil
+}
+
+func stopActiveRuns(config *Config, d *schema.ResourceData, timeout time.Duration) error {
+	runsAddr := urlJoin(getProgramAddr(config, d), "runs")
+	runs, err := getActiveRuns(config, runsAddr)
+	if err != nil {
+		return err
+	}
+	for _, r := range runs {
+		if err := stopProgramRunAndWait(config, runsAddr, r.RunID, getStopOptions(d), timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Services report the number of requested and provisioned instances, workers only the number of instances.
+type programInstances struct {
+	Instances *int `json:"instances,omitempty"`
+	Requested *int `json:"requested,omitempty"`
+}
+
+func getProgramInstances(config *Config, addr string) (int, error) {
+	req, err := http.NewRequest(http.MethodGet, urlJoin(addr, "/instances"), nil)
+	if err != nil {
+		return 0, err
+	}
+	b, err := httpCall(config, req)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't get program instances: %v", err)
+	}
+	var inst programInstances
+	if err := json.Unmarshal(b, &inst); err != nil {
+		return 0, fmt.Errorf("could not unmarshal program instances: %v", err)
+	}
+	switch {
+	case inst.Requested != nil:
+		return *inst.Requested, nil
+	case inst.Instances != nil:
+		return *inst.Instances, nil
+	}
+	return 0, fmt.Errorf("program instances missing from response: %v", string(b))
+}
+
+func setProgramInstances(config *Config, addr string, n int) error {
+	b, err := json.Marshal(&programInstances{Instances: &n})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, urlJoin(addr, "/instances"), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	if _, err := httpCall(config, req); err != nil {
+		return fmt.Errorf("failed to set program instances: %v", err)
+	}
+	return nil
+}
+
+// waitForServiceAvailable polls until the service responds to requests, which CDAP reports with
+// 503 Service Unavailable until then.
+func waitForServiceAvailable(config *Config, addr string, timeout time.Duration) error {
+	return resource.Retry(timeout, func() *resource.RetryError {
+		req, err := http.NewRequest(http.MethodGet, urlJoin(addr, "/available"), nil)
+		if err != nil {
+			return resource.NonRetryableError(err)
+		}
+		_, err = httpCall(config, req)
+		var herr *httpError
+		if errors.As(err, &herr) && herr.code == http.StatusServiceUnavailable {
+			time.Sleep(10 * time.Second)
+			return resource.RetryableError(fmt.Errorf("still waiting for service to be available: %v", err))
+		}
+		if err != nil {
+			return resource.NonRetryableError(err)
+		}
+		return nil
+	})
+}
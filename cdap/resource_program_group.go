@@ -0,0 +1,327 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdap
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// resourceProgramGroup starts a group of programs together and stops them together on destroy,
+// e.g. the streaming pipelines of a namespace around a deploy or maintenance.
+// https://docs.cdap.io/cdap/current/en/reference-manual/http-restful-api/lifecycle.html
+func resourceProgramGroup() *schema.Resource {
+	r := &schema.Resource{
+		Create: resourceProgramGroupCreate,
+		Read:   resourceProgramGroupRead,
+		Update: resourceProgramGroupUpdate,
+		Delete: resourceProgramGroupDelete,
+
+		Schema: map[string]*schema.Schema{
+			"namespace": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "The name of the namespace in which this resource belongs. If not provided, the default namespace is used.",
+				DefaultFunc: func() (interface{}, error) {
+					return defaultNamespace, nil
+				},
+			},
+			"program": {
+				Type:        schema.TypeList,
+				Required:    true,
+				ForceNew:    true,
+				MinItems:    1,
+				Description: "The programs to start together.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"app": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Name of the application.",
+						},
+						"program": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     "DataStreamsSparkStreaming",
+							Description: "Name of the program.",
+						},
+						"type": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      "spark",
+							Description:  "One of mapreduce, services, spark, workers, or workflows.",
+							ValidateFunc: validation.StringInSlice([]string{"mapreduce", "services", "spark", "workers", "workflows"}, false),
+						},
+						"runtime_arguments": {
+							Type:        schema.TypeMap,
+							Optional:    true,
+							Description: "The runtime arguments used to start the program.",
+							Elem:        &schema.Schema{Type: schema.TypeString},
+						},
+					},
+				},
+			},
+			"run": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "The run of each program, in the order of the programs.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"app": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Name of the application.",
+						},
+						"program": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Name of the program.",
+						},
+						"type": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The type of the program.",
+						},
+						"run_id": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The CDAP run ID.",
+						},
+						"status": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The status of the program as of the last refresh.",
+						},
+					},
+				},
+			},
+		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(20 * time.Minute),
+			Delete: schema.DefaultTimeout(time.Hour),
+		},
+	}
+	for k, v := range stopOptionsSchema() {
+		r.Schema[k] = v
+	}
+	return r
+}
+
+// programGroupMember is an entry of the program list with the namespace of the group, so that it
+// can be used with the helpers for single programs.
+type programGroupMember map[string]interface{}
+
+func (p programGroupMember) Get(key string) interface{} {
+	return p[key]
+}
+
+func (p programGroupMember) String() string {
+	return fmt.Sprintf("%v/%v/%v", p["app"], p["type"], p["program"])
+}
+
+func getProgramGroupMembers(d *schema.ResourceData) []programGroupMember {
+	var members []programGroupMember
+	for _, raw := range d.Get("program").([]interface{}) {
+		p := programGroupMember(raw.(map[string]interface{}))
+		p["namespace"] = d.Get("namespace")
+		members = append(members, p)
+	}
+	return members
+}
+
+func resourceProgramGroupCreate(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	namespace := d.Get("namespace").(string)
+	members := getProgramGroupMembers(d)
+
+	programs := make([]*batchProgram, len(members))
+	for i, p := range members {
+		programs[i] = newBatchProgram(p, toStringMap(p["runtime_arguments"].(map[string]interface{})))
+	}
+	started, startErrs, err := startPrograms(config, namespace, programs)
+	if err != nil {
+		return fmt.Errorf("failed to start programs: %v", err)
+	}
+	d.SetId(resource.PrefixedUniqueId(namespace + "/"))
+
+	timeout := d.Timeout(schema.TimeoutCreate)
+	runIDs := make([]string, len(members))
+	errs := runInParallel(len(members), func(i int) error {
+		if startErrs[i] != nil {
+			return startErrs[i]
+		}
+		runID, err := waitForRunning(config, urlJoin(getProgramAddr(config, members[i]), "runs"), started[i], timeout)
+		runIDs[i] = runID
+		return err
+	})
+
+	// Record the runs that did start, so that they are stopped on destroy.
+	if err := setProgramGroupRuns(config, d, members, runIDs); err != nil {
+		return err
+	}
+	return programGroupError("start", members, errs)
+}
+
+func resourceProgramGroupRead(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	return setProgramGroupRuns(config, d, getProgramGroupMembers(d), getProgramGroupRunIDs(d))
+}
+
+// Only the options for stopping the programs can be updated, and they are only used on delete.
+func resourceProgramGroupUpdate(d *schema.ResourceData, m interface{}) error {
+	return resourceProgramGroupRead(d, m)
+}
+
+// resourceProgramGroupDelete stops all programs with the batch stop API and waits for their runs to
+// end. If a graceful shutdown window is set, each run is stopped on its own to pass the window.
+func resourceProgramGroupDelete(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	namespace := d.Get("namespace").(string)
+	members := getProgramGroupMembers(d)
+	opts := getStopOptions(d)
+
+	errs := make([]error, len(members))
+	if opts.gracefulShutdownSecs < 0 {
+		var active []*batchProgram
+		var activeIdx []int
+		statuses, err := getProgramGroupStatuses(config, namespace, members)
+		if err != nil {
+			return err
+		}
+		for i, p := range members {
+			if isProgramActive(statuses[i]) {
+				active = append(active, newBatchProgram(p, nil))
+				activeIdx = append(activeIdx, i)
+			}
+		}
+		if len(active) > 0 {
+			results, err := callBatchProgramAPI(config, namespace, "/stop", active)
+			if err != nil {
+				return fmt.Errorf("failed to stop programs: %v", err)
+			}
+			for j, res := range results {
+				errs[activeIdx[j]] = res.err()
+			}
+		}
+	}
+
+	runIDs := getProgramGroupRunIDs(d)
+	timeout := d.Timeout(schema.TimeoutDelete)
+	waitErrs := runInParallel(len(members), func(i int) error {
+		if errs[i] != nil || runIDs[i] == "" {
+			return errs[i]
+		}
+		return stopProgramRunAndWait(config, urlJoin(getProgramAddr(config, members[i]), "runs"), runIDs[i], opts, timeout)
+	})
+	return programGroupError("stop", members, waitErrs)
+}
+
+func getProgramGroupRunIDs(d *schema.ResourceData) []string {
+	runs := d.Get("run").([]interface{})
+	runIDs := make([]string, len(d.Get("program").([]interface{})))
+	for i := range runIDs {
+		if i < len(runs) {
+			runIDs[i] = runs[i].(map[string]interface{})["run_id"].(string)
+		}
+	}
+	return runIDs
+}
+
+// getProgramGroupStatuses returns the status of each program with the batch status API.
+func getProgramGroupStatuses(config *Config, namespace string, members []programGroupMember) ([]string, error) {
+	programs := make([]*batchProgram, len(members))
+	for i, p := range members {
+		programs[i] = newBatchProgram(p, nil)
+	}
+	results, err := callBatchProgramAPI(config, namespace, "/status", programs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get program statuses: %v", err)
+	}
+
+	statuses := make([]string, len(members))
+	for i, res := range results {
+		if err := res.err(); err != nil {
+			log.Printf("failed to get status of program %v: %v", members[i], err)
+			continue
+		}
+		statuses[i] = res.Status
+	}
+	return statuses, nil
+}
+
+func setProgramGroupRuns(config *Config, d *schema.ResourceData, members []programGroupMember, runIDs []string) error {
+	statuses, err := getProgramGroupStatuses(config, d.Get("namespace").(string), members)
+	if err != nil {
+		return err
+	}
+
+	var runs []interface{}
+	for i, p := range members {
+		runs = append(runs, map[string]interface{}{
+			"app":     p["app"],
+			"program": p["program"],
+			"type":    p["type"],
+			"run_id":  runIDs[i],
+			"status":  statuses[i],
+		})
+	}
+	return d.Set("run", runs)
+}
+
+// runInParallel calls f for 0 to n-1 concurrently and returns the error of each call.
+func runInParallel(n int, f func(i int) error) []error {
+	return runInParallelLimit(n, n, f)
+}
+
+// runInParallelLimit is like runInParallel, but with at most limit calls running at a time.
+func runInParallelLimit(n, limit int, f func(i int) error) []error {
+	errs := make([]error, n)
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			errs[i] = f(i)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// programGroupError combines the errors of the programs into one, so that all failures are
+// reported together.
+func programGroupError(action string, members []programGroupMember, errs []error) error {
+	var msgs []string
+	for i, err := range errs {
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("%v: %v", members[i], err))
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%v of %v programs failed to %v:\n%v", len(msgs), len(members), action, strings.Join(msgs, "\n"))
+}
@@ -0,0 +1,201 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdap
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// resourceProgramRun runs a batch program to completion, e.g. for backfills or migrations.
+// https://docs.cdap.io/cdap/current/en/reference-manual/http-restful-api/lifecycle.html.
+func resourceProgramRun() *schema.Resource {
+	r := &schema.Resource{
+		Create: resourceProgramRunCreate,
+		Read:   resourceProgramRunRead,
+		Update: resourceProgramRunUpdate,
+		Delete: resourceProgramRunDelete,
+
+		CustomizeDiff: customizeDiffMacroCoverage,
+
+		Schema: map[string]*schema.Schema{
+			"namespace": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "The name of the namespace in which this resource belongs. If not provided, the default namespace is used.",
+				DefaultFunc: func() (interface{}, error) {
+					return defaultNamespace, nil
+				},
+			},
+			"app": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Name of the application.",
+			},
+			"program": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     "DataPipelineWorkflow",
+				Description: "Name of the program.",
+			},
+			"type": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				Default:      "workflows",
+				Description:  "One of mapreduce, spark, or workflows.",
+				ValidateFunc: validation.StringInSlice([]string{"mapreduce", "spark", "workflows"}, false),
+			},
+			"runtime_arguments": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "The runtime arguments used to start the program.",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"required_macros": {
+				Type:        schema.TypeSet,
+				Optional:    true,
+				Description: "The macros the program needs, usually the macros attribute of the application. The plan fails if the runtime arguments and the resolved preferences do not cover them. Changing them does not run the program again.",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"triggers": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "Arbitrary values that cause the program to be run again when changed.",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"run_id": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The CDAP run ID.",
+			},
+			"status": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The status of the run.",
+			},
+			"start_time": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The time the run started, in RFC3339 format.",
+			},
+			"end_time": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The time the run ended, in RFC3339 format.",
+			},
+		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(time.Hour),
+			Delete: schema.DefaultTimeout(20 * time.Minute),
+		},
+	}
+	for k, v := range stopOptionsSchema() {
+		r.Schema[k] = v
+	}
+	return r
+}
+
+func resourceProgramRunCreate(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+
+	addr := getProgramAddr(config, d)
+	runsAddr := urlJoin(addr, "runs")
+
+	started, err := startProgram(config, d, toStringMap(d.Get("runtime_arguments").(map[string]interface{})))
+	if err != nil {
+		return err
+	}
+
+	// Poll until the run reaches an end status.
+	return resource.Retry(d.Timeout(schema.TimeoutCreate), func() *resource.RetryError {
+		time.Sleep(10 * time.Second) // avoid spamming retries and initial failure to find run.
+		if d.Id() == "" {
+			runID, err := started.id(config, runsAddr)
+			if err != nil {
+				return resource.NonRetryableError(err)
+			}
+			// Track the run as soon as it is known, so that it is stopped on destroy if the create times out.
+			d.SetId(runID)
+		}
+
+		r, err := getRunByID(config, runsAddr, d.Id())
+		if err != nil {
+			return resource.NonRetryableError(err)
+		}
+		setProgramRunAttributes(d, r)
+
+		if r.Status == "COMPLETED" {
+			return nil
+		}
+		if programRunUnsuccessfulStatuses[r.Status] {
+			err := fmt.Errorf("program run %v ended in state: %v", r.RunID, r.Status)
+			return resource.NonRetryableError(withRunLogs(config, runsAddr, r.RunID, err))
+		}
+		return resource.RetryableError(fmt.Errorf("still waiting for program run with id: %v which is in state: %v", r.RunID, r.Status))
+	})
+}
+
+func setProgramRunAttributes(d *schema.ResourceData, r *run) {
+	d.Set("run_id", r.RunID)
+	setRunStatusAttributes(d, r)
+}
+
+// formatRunTime formats the seconds since epoch of a run record, which are zero if unset.
+func formatRunTime(secs int64) string {
+	if secs == 0 {
+		return ""
+	}
+	return time.Unix(secs, 0).UTC().Format(time.RFC3339)
+}
+
+func resourceProgramRunRead(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+
+	runsAddr := urlJoin(getProgramAddr(config, d), "runs")
+	r, err := getRunByID(config, runsAddr, d.Id())
+	if isNotFound(err) {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	setProgramRunAttributes(d, r)
+	return nil
+}
+
+// Only the options for stopping the run and required_macros can be updated. The stop options are
+// only used on delete, and required_macros are only checked during plan.
+func resourceProgramRunUpdate(d *schema.ResourceData, m interface{}) error {
+	return resourceProgramRunRead(d, m)
+}
+
+// A finished run cannot be undone, so deleting only stops the run if it is still active.
+func resourceProgramRunDelete(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+
+	runsAddr := urlJoin(getProgramAddr(config, d), "runs")
+	return stopProgramRunAndWait(config, runsAddr, d.Id(), getStopOptions(d), d.Timeout(schema.TimeoutDelete))
+}
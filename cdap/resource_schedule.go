@@ -0,0 +1,808 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+var (
+	scheduleTriggerKeys       = []string{"time_trigger", "partition_trigger", "program_status_trigger", "trigger_group"}
+	scheduleProgramStatuses   = []string{"COMPLETED", "FAILED", "KILLED"}
+	scheduleProgramTypes      = []string{"WORKFLOW", "MAPREDUCE", "SPARK"}
+	scheduleConstraintSchemas = map[string]map[string]*schema.Schema{
+		"concurrency_constraint": {
+			"max_concurrency": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				Description: "The maximum number of concurrent runs of the program.",
+			},
+		},
+		"delay_constraint": {
+			"millis_after_trigger": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				Description: "The milliseconds to wait after the trigger fires before starting the program.",
+			},
+		},
+		"time_range_constraint": {
+			"start_time": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "The start of the time range in which the program may start, in HH:mm format.",
+			},
+			"end_time": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "The end of the time range in which the program may start, in HH:mm format.",
+			},
+			"time_zone": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "UTC",
+				Description: "The time zone of the time range.",
+			},
+		},
+		"last_run_constraint": {
+			"millis_since_last_run": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				Description: "The minimum milliseconds since the last run of the program started.",
+			},
+		},
+	}
+)
+
+// https://docs.cdap.io/cdap/current/en/reference-manual/http-restful-api/lifecycle.html.
+func resourceSchedule() *schema.Resource {
+	s := map[string]*schema.Schema{
+		"namespace": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			ForceNew:    true,
+			Description: "The name of the namespace in which this resource belongs. If not provided, the default namespace is used.",
+			DefaultFunc: func() (interface{}, error) {
+				return defaultNamespace, nil
+			},
+		},
+		"app": {
+			Type:        schema.TypeString,
+			Required:    true,
+			ForceNew:    true,
+			Description: "Name of the application.",
+		},
+		"name": {
+			Type:        schema.TypeString,
+			Required:    true,
+			ForceNew:    true,
+			Description: "The name of the schedule.",
+		},
+		"description": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "A description of the schedule.",
+		},
+		"program": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			ForceNew:    true,
+			Default:     "DataPipelineWorkflow",
+			Description: "Name of the program to start.",
+		},
+		"program_type": {
+			Type:         schema.TypeString,
+			Optional:     true,
+			ForceNew:     true,
+			Default:      "WORKFLOW",
+			Description:  "The type of the program to start. One of WORKFLOW, MAPREDUCE or SPARK.",
+			ValidateFunc: validation.StringInSlice(scheduleProgramTypes, false),
+		},
+		"properties": {
+			Type:        schema.TypeMap,
+			Optional:    true,
+			Description: "The properties of the schedule, which are passed to the program as runtime arguments.",
+			Elem:        &schema.Schema{Type: schema.TypeString},
+		},
+		"timeout_millis": {
+			Type:        schema.TypeInt,
+			Optional:    true,
+			Computed:    true,
+			Description: "The milliseconds after which the schedule is aborted if its constraints are not met.",
+		},
+		"trigger_operator": {
+			Type:         schema.TypeString,
+			Optional:     true,
+			Default:      "OR",
+			Description:  "How multiple triggers are combined. One of AND or OR.",
+			ValidateFunc: validation.StringInSlice([]string{"AND", "OR"}, false),
+		},
+		"trigger_json": {
+			Type:        schema.TypeString,
+			Computed:    true,
+			Description: "The trigger of the schedule as reported by CDAP, including composite triggers nested deeper than trigger_group blocks allow.",
+		},
+		"enabled": {
+			Type:        schema.TypeBool,
+			Optional:    true,
+			Default:     true,
+			Description: "Whether the schedule is enabled. Disabled schedules are suspended.",
+		},
+	}
+	for k, v := range scheduleConstraintsSchema() {
+		s[k] = v
+	}
+	for k, v := range scheduleTriggerSchema(scheduleTriggerGroupDepth) {
+		v.AtLeastOneOf = scheduleTriggerKeys
+		s[k] = v
+	}
+
+	return &schema.Resource{
+		Create: resourceScheduleCreate,
+		Read:   resourceScheduleRead,
+		Update: resourceScheduleUpdate,
+		Delete: resourceScheduleDelete,
+		Importer: &schema.ResourceImporter{
+			State: resourceScheduleImport,
+		},
+
+		CustomizeDiff: resourceScheduleCustomizeDiff,
+
+		Schema: s,
+	}
+}
+
+// The number of levels of trigger_group blocks that can be nested. Terraform schemas cannot be
+// recursive, so deeper composite triggers are only recorded in trigger_json.
+const scheduleTriggerGroupDepth = 3
+
+// scheduleTriggerSchema returns the trigger blocks of a schedule or of a trigger_group, with up to
+// depth levels of nested trigger_group blocks.
+func scheduleTriggerSchema(depth int) map[string]*schema.Schema {
+	s := map[string]*schema.Schema{
+		"time_trigger": {
+			Type:        schema.TypeList,
+			Optional:    true,
+			Description: "Triggers the program on a cron schedule.",
+			Elem: &schema.Resource{
+				Schema: map[string]*schema.Schema{
+					"cron_expression": {
+						Type:        schema.TypeString,
+						Required:    true,
+						Description: "The cron expression, e.g. 0 1 * * *.",
+					},
+				},
+			},
+		},
+		"partition_trigger": {
+			Type:        schema.TypeList,
+			Optional:    true,
+			Description: "Triggers the program when partitions are added to a dataset.",
+			Elem: &schema.Resource{
+				Schema: map[string]*schema.Schema{
+					"dataset_namespace": {
+						Type:        schema.TypeString,
+						Optional:    true,
+						Computed:    true,
+						Description: "The namespace of the dataset. If not provided, the namespace of the schedule is used.",
+					},
+					"dataset": {
+						Type:        schema.TypeString,
+						Required:    true,
+						Description: "The name of the partitioned dataset.",
+					},
+					"num_partitions": {
+						Type:        schema.TypeInt,
+						Optional:    true,
+						Default:     1,
+						Description: "The number of new partitions that fire the trigger.",
+					},
+				},
+			},
+		},
+		"program_status_trigger": {
+			Type:        schema.TypeList,
+			Optional:    true,
+			Description: "Triggers the program when another program reaches one of the given statuses.",
+			Elem: &schema.Resource{
+				Schema: scheduleProgramStatusTriggerSchema(),
+			},
+		},
+	}
+	if depth == 0 {
+		return s
+	}
+
+	group := scheduleTriggerSchema(depth - 1)
+	group["operator"] = &schema.Schema{
+		Type:         schema.TypeString,
+		Required:     true,
+		Description:  "How the triggers of the group are combined. One of AND or OR.",
+		ValidateFunc: validation.StringInSlice([]string{"AND", "OR"}, false),
+	}
+	s["trigger_group"] = &schema.Schema{
+		Type:        schema.TypeList,
+		Optional:    true,
+		Description: fmt.Sprintf("A composite trigger that combines its triggers with its own operator, e.g. to fire when A AND (B OR C). Groups can be nested %d levels deep.", scheduleTriggerGroupDepth),
+		Elem:        &schema.Resource{Schema: group},
+	}
+	return s
+}
+
+// resourceScheduleCustomizeDiff rejects trigger_group blocks that would be read back differently,
+// so that the plan does not show a diff after every refresh.
+func resourceScheduleCustomizeDiff(_ context.Context, d *schema.ResourceDiff, _ interface{}) error {
+	groups := len(d.Get("trigger_group").([]interface{}))
+	others := 0
+	for _, k := range scheduleTriggerKeys {
+		if k != "trigger_group" {
+			others += len(d.Get(k).([]interface{}))
+		}
+	}
+	if groups == 1 && others == 0 {
+		return fmt.Errorf("a single trigger_group is equivalent to its triggers with trigger_operator; set them at the top level instead")
+	}
+	return nil
+}
+
+func scheduleProgramStatusTriggerSchema() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		"namespace": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Computed:    true,
+			Description: "The namespace of the triggering program. If not provided, the namespace of the schedule is used.",
+		},
+		"app": {
+			Type:        schema.TypeString,
+			Required:    true,
+			Description: "The application of the triggering program.",
+		},
+		"program": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Default:     "DataPipelineWorkflow",
+			Description: "The name of the triggering program.",
+		},
+		"program_type": {
+			Type:         schema.TypeString,
+			Optional:     true,
+			Default:      "WORKFLOW",
+			Description:  "The type of the triggering program. One of WORKFLOW, MAPREDUCE or SPARK.",
+			ValidateFunc: validation.StringInSlice(scheduleProgramTypes, false),
+		},
+		"statuses": {
+			Type:        schema.TypeSet,
+			Required:    true,
+			Description: "The statuses of the triggering program that fire the trigger. Any of COMPLETED, FAILED or KILLED.",
+			Elem: &schema.Schema{
+				Type:         schema.TypeString,
+				ValidateFunc: validation.StringInSlice(scheduleProgramStatuses, false),
+			},
+		},
+	}
+}
+
+// scheduleConstraintsSchema returns a block for each constraint type, which all support wait_until_met.
+func scheduleConstraintsSchema() map[string]*schema.Schema {
+	res := make(map[string]*schema.Schema)
+	for name, fields := range scheduleConstraintSchemas {
+		s := map[string]*schema.Schema{
+			"wait_until_met": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Whether to wait until the constraint is met instead of skipping the run.",
+			},
+		}
+		for k, v := range fields {
+			s[k] = v
+		}
+		res[name] = &schema.Schema{
+			Type:        schema.TypeList,
+			Optional:    true,
+			MaxItems:    1,
+			Description: fmt.Sprintf("A %s constraint on starting the program.", strings.TrimSuffix(strings.ReplaceAll(name, "_", " "), " constraint")),
+			Elem:        &schema.Resource{Schema: s},
+		}
+	}
+	return res
+}
+
+type schedule struct {
+	Name          string                `json:"name"`
+	Description   string                `json:"description"`
+	Program       *scheduleProgram      `json:"program"`
+	Properties    map[string]string     `json:"properties"`
+	Constraints   []*scheduleConstraint `json:"constraints"`
+	Trigger       *scheduleTrigger      `json:"trigger"`
+	TimeoutMillis int64                 `json:"timeoutMillis,omitempty"`
+}
+
+type scheduleProgram struct {
+	ProgramName string `json:"programName"`
+	ProgramType string `json:"programType"`
+}
+
+type scheduleTrigger struct {
+	Type            string             `json:"type"`
+	CronExpression  string             `json:"cronExpression,omitempty"`
+	Dataset         *datasetID         `json:"dataset,omitempty"`
+	NumPartitions   int                `json:"numPartitions,omitempty"`
+	ProgramID       *programID         `json:"programId,omitempty"`
+	ProgramStatuses []string           `json:"programStatuses,omitempty"`
+	Triggers        []*scheduleTrigger `json:"triggers,omitempty"`
+}
+
+type datasetID struct {
+	Namespace string `json:"namespace"`
+	Dataset   string `json:"dataset"`
+}
+
+type programID struct {
+	Namespace   string `json:"namespace"`
+	Application string `json:"application"`
+	Version     string `json:"version"`
+	Type        string `json:"type"`
+	Entity      string `json:"entity"`
+	Program     string `json:"program"`
+}
+
+type scheduleConstraint struct {
+	Type               string `json:"type"`
+	WaitUntilMet       bool   `json:"waitUntilMet"`
+	MaxConcurrency     int    `json:"maxConcurrency,omitempty"`
+	MillisAfterTrigger int64  `json:"millisAfterTrigger,omitempty"`
+	StartTime          string `json:"startTime,omitempty"`
+	EndTime            string `json:"endTime,omitempty"`
+	TimeZone           string `json:"timeZone,omitempty"`
+	MillisSinceLastRun int64  `json:"millisSinceLastRun,omitempty"`
+}
+
+func resourceScheduleCreate(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	s, err := expandSchedule(d)
+	if err != nil {
+		return err
+	}
+
+	addr := getScheduleAddr(config, d)
+	if err := putSchedule(config, addr, s); err != nil {
+		return err
+	}
+	d.SetId(scheduleID(d))
+
+	if err := setScheduleEnabled(config, addr, d.Get("enabled").(bool)); err != nil {
+		return err
+	}
+	return resourceScheduleRead(d, m)
+}
+
+func resourceScheduleRead(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	addr := getScheduleAddr(config, d)
+
+	s, enabled, err := getSchedule(config, addr)
+	if isNotFound(err) {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	d.Set("description", s.Description)
+	d.Set("program", s.Program.ProgramName)
+	d.Set("program_type", s.Program.ProgramType)
+	d.Set("properties", s.Properties)
+	d.Set("timeout_millis", s.TimeoutMillis)
+	d.Set("enabled", enabled)
+	if err := flattenScheduleConstraints(d, s.Constraints); err != nil {
+		return err
+	}
+	return flattenScheduleTrigger(d, s.Trigger)
+}
+
+func resourceScheduleUpdate(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	addr := getScheduleAddr(config, d)
+
+	if d.HasChangeExcept("enabled") {
+		s, err := expandSchedule(d)
+		if err != nil {
+			return err
+		}
+		if err := updateSchedule(config, addr, s); err != nil {
+			return err
+		}
+	}
+
+	if err := setScheduleEnabled(config, addr, d.Get("enabled").(bool)); err != nil {
+		return err
+	}
+	return resourceScheduleRead(d, m)
+}
+
+func resourceScheduleDelete(d *schema.ResourceData, m interface{}) error {
+	config := m.(*Config)
+	return deleteSchedule(config, getScheduleAddr(config, d))
+}
+
+// resourceScheduleImport imports a schedule by an ID in the form namespace/app/name.
+func resourceScheduleImport(d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	parts := strings.Split(d.Id(), "/")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("unexpected import id %q: want namespace/app/name", d.Id())
+	}
+	d.Set("namespace", parts[0])
+	d.Set("app", parts[1])
+	d.Set("name", parts[2])
+	return []*schema.ResourceData{d}, nil
+}
+
+func scheduleID(d resourceGetter) string {
+	return strings.Join([]string{d.Get("namespace").(string), d.Get("app").(string), d.Get("name").(string)}, "/")
+}
+
+func getScheduleAddr(config *Config, d resourceGetter) string {
+	return urlJoin(
+		config.host,
+		"/v3/namespaces", d.Get("namespace").(string),
+		"/apps", d.Get("app").(string),
+		"/schedules", d.Get("name").(string))
+}
+
+func expandSchedule(d *schema.ResourceData) (*schedule, error) {
+	namespace := d.Get("namespace").(string)
+	raw := make(map[string]interface{})
+	for _, k := range scheduleTriggerKeys {
+		raw[k] = d.Get(k)
+	}
+	triggers, err := expandScheduleTriggers(namespace, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	trigger := triggers[0]
+	if len(triggers) > 1 {
+		trigger = &scheduleTrigger{
+			Type:     d.Get("trigger_operator").(string),
+			Triggers: triggers,
+		}
+	}
+
+	return &schedule{
+		Name:        d.Get("name").(string),
+		Description: d.Get("description").(string),
+		Program: &scheduleProgram{
+			ProgramName: d.Get("program").(string),
+			ProgramType: d.Get("program_type").(string),
+		},
+		Properties:    toStringMap(d.Get("properties").(map[string]interface{})),
+		Constraints:   expandScheduleConstraints(d),
+		Trigger:       trigger,
+		TimeoutMillis: int64(d.Get("timeout_millis").(int)),
+	}, nil
+}
+
+// expandScheduleTriggers returns the triggers of the trigger blocks of a schedule or trigger_group.
+func expandScheduleTriggers(namespace string, raw map[string]interface{}) ([]*scheduleTrigger, error) {
+	var triggers []*scheduleTrigger
+	for _, r := range raw["time_trigger"].([]interface{}) {
+		t := r.(map[string]interface{})
+		triggers = append(triggers, &scheduleTrigger{
+			Type:           "TIME",
+			CronExpression: t["cron_expression"].(string),
+		})
+	}
+	for _, r := range raw["partition_trigger"].([]interface{}) {
+		t := r.(map[string]interface{})
+		ns := t["dataset_namespace"].(string)
+		if ns == "" {
+			ns = namespace
+		}
+		triggers = append(triggers, &scheduleTrigger{
+			Type:          "PARTITION",
+			Dataset:       &datasetID{Namespace: ns, Dataset: t["dataset"].(string)},
+			NumPartitions: t["num_partitions"].(int),
+		})
+	}
+	for _, r := range raw["program_status_trigger"].([]interface{}) {
+		triggers = append(triggers, expandProgramStatusTrigger(namespace, r.(map[string]interface{})))
+	}
+	groups, _ := raw["trigger_group"].([]interface{})
+	for _, r := range groups {
+		g := r.(map[string]interface{})
+		children, err := expandScheduleTriggers(namespace, g)
+		if err != nil {
+			return nil, err
+		}
+		if len(children) < 2 {
+			return nil, fmt.Errorf("a trigger_group must have at least 2 triggers")
+		}
+		triggers = append(triggers, &scheduleTrigger{Type: g["operator"].(string), Triggers: children})
+	}
+	return triggers, nil
+}
+
+func expandProgramStatusTrigger(namespace string, t map[string]interface{}) *scheduleTrigger {
+	ns := t["namespace"].(string)
+	if ns == "" {
+		ns = namespace
+	}
+	var statuses []string
+	for _, s := range t["statuses"].(*schema.Set).List() {
+		statuses = append(statuses, s.(string))
+	}
+	return &scheduleTrigger{
+		Type: "PROGRAM_STATUS",
+		ProgramID: &programID{
+			Namespace:   ns,
+			Application: t["app"].(string),
+			Version:     "-SNAPSHOT",
+			Type:        t["program_type"].(string),
+			Entity:      "PROGRAM",
+			Program:     t["program"].(string),
+		},
+		ProgramStatuses: statuses,
+	}
+}
+
+func flattenProgramStatusTrigger(t *scheduleTrigger) map[string]interface{} {
+	var statuses []interface{}
+	for _, s := range t.ProgramStatuses {
+		statuses = append(statuses, s)
+	}
+	return map[string]interface{}{
+		"namespace":    t.ProgramID.Namespace,
+		"app":          t.ProgramID.Application,
+		"program":      t.ProgramID.Program,
+		"program_type": t.ProgramID.Type,
+		"statuses":     statuses,
+	}
+}
+
+func flattenScheduleTrigger(d *schema.ResourceData, trigger *scheduleTrigger) error {
+	b, err := json.Marshal(trigger)
+	if err != nil {
+		return err
+	}
+	d.Set("trigger_json", string(b))
+
+	triggers := []*scheduleTrigger{trigger}
+	if isCompositeTrigger(trigger) {
+		d.Set("trigger_operator", trigger.Type)
+		triggers = trigger.Triggers
+	}
+	flat := flattenScheduleTriggers(triggers, scheduleTriggerGroupDepth)
+	for _, k := range scheduleTriggerKeys {
+		if err := d.Set(k, flat[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isCompositeTrigger(t *scheduleTrigger) bool {
+	return t.Type == "AND" || t.Type == "OR"
+}
+
+// flattenScheduleTriggers returns the trigger blocks of the triggers, with composite triggers as
+// trigger_group blocks up to depth levels deep. Deeper triggers are only kept in trigger_json, so
+// that schedules created elsewhere can still be refreshed and imported.
+func flattenScheduleTriggers(triggers []*scheduleTrigger, depth int) map[string]interface{} {
+	flat := make(map[string]interface{})
+	add := func(k string, v interface{}) {
+		l, _ := flat[k].([]interface{})
+		flat[k] = append(l, v)
+	}
+	for _, t := range triggers {
+		switch {
+		case t.Type == "TIME":
+			add("time_trigger", map[string]interface{}{
+				"cron_expression": t.CronExpression,
+			})
+		case t.Type == "PARTITION":
+			add("partition_trigger", map[string]interface{}{
+				"dataset_namespace": t.Dataset.Namespace,
+				"dataset":           t.Dataset.Dataset,
+				"num_partitions":    t.NumPartitions,
+			})
+		case t.Type == "PROGRAM_STATUS":
+			add("program_status_trigger", flattenProgramStatusTrigger(t))
+		case isCompositeTrigger(t) && depth > 0:
+			group := flattenScheduleTriggers(t.Triggers, depth-1)
+			group["operator"] = t.Type
+			add("trigger_group", group)
+		default:
+			log.Printf("trigger of type %v is only recorded in trigger_json", t.Type)
+		}
+	}
+	return flat
+}
+
+func expandScheduleConstraints(d *schema.ResourceData) []*scheduleConstraint {
+	constraints := []*scheduleConstraint{}
+	for name := range scheduleConstraintSchemas {
+		raw := d.Get(name).([]interface{})
+		if len(raw) == 0 || raw[0] == nil {
+			continue
+		}
+		c := raw[0].(map[string]interface{})
+		sc := &scheduleConstraint{WaitUntilMet: c["wait_until_met"].(bool)}
+		switch name {
+		case "concurrency_constraint":
+			sc.Type = "CONCURRENCY"
+			sc.MaxConcurrency = c["max_concurrency"].(int)
+		case "delay_constraint":
+			sc.Type = "DELAY"
+			sc.MillisAfterTrigger = int64(c["millis_after_trigger"].(int))
+		case "time_range_constraint":
+			sc.Type = "TIME_RANGE"
+			sc.StartTime = c["start_time"].(string)
+			sc.EndTime = c["end_time"].(string)
+			sc.TimeZone = c["time_zone"].(string)
+		case "last_run_constraint":
+			sc.Type = "LAST_RUN"
+			sc.MillisSinceLastRun = int64(c["millis_since_last_run"].(int))
+		}
+		constraints = append(constraints, sc)
+	}
+	return constraints
+}
+
+func flattenScheduleConstraints(d *schema.ResourceData, constraints []*scheduleConstraint) error {
+	flat := make(map[string][]interface{})
+	for _, c := range constraints {
+		switch c.Type {
+		case "CONCURRENCY":
+			flat["concurrency_constraint"] = []interface{}{map[string]interface{}{
+				"wait_until_met":  c.WaitUntilMet,
+				"max_concurrency": c.MaxConcurrency,
+			}}
+		case "DELAY":
+			flat["delay_constraint"] = []interface{}{map[string]interface{}{
+				"wait_until_met":       c.WaitUntilMet,
+				"millis_after_trigger": c.MillisAfterTrigger,
+			}}
+		case "TIME_RANGE":
+			flat["time_range_constraint"] = []interface{}{map[string]interface{}{
+				"wait_until_met": c.WaitUntilMet,
+				"start_time":     c.StartTime,
+				"end_time":       c.EndTime,
+				"time_zone":      c.TimeZone,
+			}}
+		case "LAST_RUN":
+			flat["last_run_constraint"] = []interface{}{map[string]interface{}{
+				"wait_until_met":        c.WaitUntilMet,
+				"millis_since_last_run": c.MillisSinceLastRun,
+			}}
+		default:
+			return fmt.Errorf("unsupported constraint type %q", c.Type)
+		}
+	}
+
+	for name := range scheduleConstraintSchemas {
+		if err := d.Set(name, flat[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putSchedule(config *Config, addr string, s *schedule) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, addr, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	_, err = httpCall(config, req)
+	return err
+}
+
+func updateSchedule(config *Config, addr string, s *schedule) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, urlJoin(addr, "/update"), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	_, err = httpCall(config, req)
+	return err
+}
+
+// getSchedule returns the schedule and whether it is enabled.
+func getSchedule(config *Config, addr string) (*schedule, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, addr, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	b, err := httpCall(config, req)
+	if err != nil {
+		return nil, false, err
+	}
+	var s *schedule
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, false, fmt.Errorf("could not unmarshal schedule: %v", err)
+	}
+
+	status, err := getScheduleStatus(config, addr)
+	if err != nil {
+		return nil, false, err
+	}
+	return s, status == "SCHEDULED", nil
+}
+
+func getScheduleStatus(config *Config, addr string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, urlJoin(addr, "/status"), nil)
+	if err != nil {
+		return "", err
+	}
+	b, err := httpCall(config, req)
+	if err != nil {
+		return "", err
+	}
+	var status struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(b, &status); err != nil {
+		return "", fmt.Errorf("could not unmarshal schedule status: %v", err)
+	}
+	return status.Status, nil
+}
+
+// setScheduleEnabled enables or suspends the schedule if it is not in the desired state already.
+func setScheduleEnabled(config *Config, addr string, enabled bool) error {
+	status, err := getScheduleStatus(config, addr)
+	if err != nil {
+		return err
+	}
+	if (status == "SCHEDULED") == enabled {
+		return nil
+	}
+
+	action := "/disable"
+	if enabled {
+		action = "/enable"
+	}
+	req, err := http.NewRequest(http.MethodPost, urlJoin(addr, action), nil)
+	if err != nil {
+		return err
+	}
+	_, err = httpCall(config, req)
+	return err
+}
+
+func deleteSchedule(config *Config, addr string) error {
+	req, err := http.NewRequest(http.MethodDelete, addr, nil)
+	if err != nil {
+		return err
+	}
+	_, err = httpCall(config, req)
+	return err
+}
@@ -16,22 +16,30 @@ package cdap
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
-// This is a special key terraform will use to check for the existence of this run related to a particular resource
-const fauxRunID = "__FAUX_RUN_ID__"
+// This is the default runtime argument terraform adds to find the run of a program it started, if
+// CDAP does not return the run id.
+const defaultRunCorrelationArgument = "__FAUX_RUN_ID__"
 
 // https://github.com/cdapio/cdap/blob/1d62163faaecb5b888f4bccd0fcf4a8d27bbd549/cdap-proto/src/main/java/io/cdap/cdap/proto/ProgramRunStatus.java
 var (
@@ -42,11 +50,13 @@ var (
 
 // https://docs.cdap.io/cdap/current/en/reference-manual/http-restful-api/lifecycle.html.
 func resourceStreamingProgramRun() *schema.Resource {
-	return &schema.Resource{
-		Create: resourceStreamingProgramRunCreate,
-		Read:   resourceStreamingProgramRunRead,
-		Delete: resourceStreamingProgramRunDelete,
-		Exists: resourceStreamingProgramRunExists,
+	r := &schema.Resource{
+		Create:      resourceStreamingProgramRunCreate,
+		ReadContext: resourceStreamingProgramRunRead,
+		Update:      resourceStreamingProgramRunUpdate,
+		Delete:      resourceStreamingProgramRunDelete,
+
+		CustomizeDiff: customdiff.All(customizeDiffMacroCoverage, resourceStreamingProgramRunCustomizeDiff),
 
 		Schema: map[string]*schema.Schema{
 			"namespace": {
@@ -77,8 +87,8 @@ func resourceStreamingProgramRun() *schema.Resource {
 				Type:         schema.TypeString,
 				Required:     true,
 				ForceNew:     true,
-				Description:  "One of flows, mapreduce, services, spark, workers, or workflows.",
-				ValidateFunc: validation.StringInSlice([]string{"flows", "mapreduce", "services", "spark", "workers", "workflows"}, false),
+				Description:  "One of mapreduce, services, spark, workers, or workflows. flows is deprecated, as CDAP 6.0 removed flows, and is only supported by older versions.",
+				ValidateFunc: validateStreamingProgramType,
 				DefaultFunc: func() (interface{}, error) {
 					return "spark", nil
 				},
@@ -86,114 +96,573 @@ func resourceStreamingProgramRun() *schema.Resource {
 			"runtime_arguments": {
 				Type:        schema.TypeMap,
 				Required:    true,
-				ForceNew:    true,
-				Description: "The runtime arguments used to start the program",
+				Description: "The runtime arguments used to start the program. Changing them restarts the program according to restart_strategy.",
 				Elem:        &schema.Schema{Type: schema.TypeString},
 			},
+			"restart_strategy": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "stop_then_start",
+				Description:  "How to restart the program when the runtime arguments change. One of stop_then_start, or start_then_stop for programs that allow concurrent runs.",
+				ValidateFunc: validation.StringInSlice([]string{"stop_then_start", "start_then_stop"}, false),
+			},
+			"required_macros": {
+				Type:        schema.TypeSet,
+				Optional:    true,
+				Description: "The macros the program needs, usually the macros attribute of the application. The plan fails if the runtime arguments and the resolved preferences do not cover them.",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"restart_on_failure": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Whether to restart the program in place if the run is found to have ended, e.g. because it failed. Otherwise the run is removed from state and started again.",
+			},
 			"run_id": {
 				Type:        schema.TypeString,
 				Computed:    true,
-				ForceNew:    true,
 				Description: "The run the CDAP Run ID",
 				Elem:        &schema.Schema{Type: schema.TypeString},
 			},
+			"status": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The status of the run as of the last refresh.",
+			},
+			"start_time": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The time the run started, in RFC3339 format.",
+			},
+			"end_time": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The time the run ended, in RFC3339 format.",
+			},
+			"termination_reason": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Why the run ended, e.g. the last ERROR log line of a failed run.",
+			},
+			"orphaned_run_ids": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Runs started by a restart that could not be stopped, e.g. the previous run if it failed to stop after the new run started. They are stopped on delete and dropped once they end.",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
 		},
 		Timeouts: &schema.ResourceTimeout{
 			Create: schema.DefaultTimeout(20 * time.Minute),
+			Update: schema.DefaultTimeout(time.Hour), // A restart may have to wait for the old run to drain
 			Delete: schema.DefaultTimeout(time.Hour), // This gives the pipeline time to drain processing if in-flight records
 		},
 	}
+	for k, v := range stopOptionsSchema() {
+		r.Schema[k] = v
+	}
+	return r
+}
+
+// validateStreamingProgramType accepts the program types with runs, and warns about flows, which
+// were removed in CDAP 6.0 but are kept so that configs for older versions still validate.
+func validateStreamingProgramType(v interface{}, k string) ([]string, []error) {
+	warnings, errs := validation.StringInSlice([]string{"flows", "mapreduce", "services", "spark", "workers", "workflows"}, false)(v, k)
+	if v.(string) == "flows" {
+		warnings = append(warnings, fmt.Sprintf("%v flows is deprecated, as CDAP 6.0 removed flows, and will be removed in a future version of the provider", k))
+	}
+	return warnings, errs
 }
 
 func resourceStreamingProgramRunCreate(d *schema.ResourceData, m interface{}) error {
 	config := m.(*Config)
 
-	addr := getProgramAddr(config, d)
-	startAddr := urlJoin(addr, "start")
-	runsAddr := urlJoin(addr, "runs")
+	args := toStringMap(d.Get("runtime_arguments").(map[string]interface{}))
+	runID, err := startProgramAndWaitForRunning(config, d, args, d.Timeout(schema.TimeoutCreate))
+	if err != nil {
+		return err
+	}
 
-	argsObj := make(map[string]string)
-	// cast map[string]interface{} to map[string]string
-	for k, val := range d.Get("runtime_arguments").(map[string]interface{}) {
-		argsObj[k] = val.(string)
+	d.Set("run_id", runID)
+	d.SetId(runID)
+	return setStreamingProgramRunAttributes(config, d)
+}
+
+// resourceStreamingProgramRunUpdate restarts the program with the new runtime arguments, or a run
+// that ended if restart_on_failure is set, and records the new run in place.
+func resourceStreamingProgramRunUpdate(d *schema.ResourceData, m interface{}) error {
+	if !d.HasChanges("runtime_arguments", "status") {
+		return nil
 	}
+	config := m.(*Config)
 
-	randomID, err := uuid.NewRandom()
-	if err != nil {
-		return fmt.Errorf("error generating uuid for faux run id: %v", err)
+	addr := getProgramAddr(config, d)
+	runsAddr := urlJoin(addr, "runs")
+	oldRunID := d.Id()
+	args := toStringMap(d.Get("runtime_arguments").(map[string]interface{}))
+	timeout := d.Timeout(schema.TimeoutUpdate)
+
+	// If the restart fails, the state records the run that is left running, so that the next apply
+	// neither loses track of it nor restarts it again while another run is still running.
+	keepOldRun := func() {
+		for _, k := range []string{"runtime_arguments", "run_id", "status", "start_time", "end_time", "termination_reason"} {
+			old, _ := d.GetChange(k)
+			d.Set(k, old)
+		}
+		d.SetId(oldRunID)
+	}
+	adoptRun := func(runID string) {
+		d.Set("run_id", runID)
+		d.SetId(runID)
+		if err := setStreamingProgramRunAttributes(config, d); err != nil {
+			log.Printf("failed to record run %v: %v", runID, err)
+		}
+	}
+	orphanRun := func(runID string) {
+		d.Set("orphaned_run_ids", append(d.Get("orphaned_run_ids").([]interface{}), runID))
 	}
-	// This runtime arg will be unused by the pipeline but will allow the provider to associate a run with this resource.
-	argsObj[fauxRunID] = randomID.String()
 
-	b, err := json.Marshal(argsObj)
-	if err != nil {
-		return err
+	if d.Get("restart_strategy").(string) == "start_then_stop" {
+		runID, err := startProgramAndWaitForRunning(config, d, args, timeout)
+		if err != nil {
+			keepOldRun()
+			if runID == "" {
+				return fmt.Errorf("failed to start new run, previous run %v was left running: %v", oldRunID, err)
+			}
+			if stopErr := stopProgramRunAndWait(config, runsAddr, runID, getStopOptions(d), timeout); stopErr != nil {
+				orphanRun(runID)
+				return fmt.Errorf("new run %v did not reach RUNNING and failed to stop, it was recorded in orphaned_run_ids and previous run %v was left running: %v; failed to stop: %v", runID, oldRunID, err, stopErr)
+			}
+			return fmt.Errorf("new run %v did not reach RUNNING and was stopped, previous run %v was left running: %v", runID, oldRunID, err)
+		}
+
+		if err := stopProgramRunAndWait(config, runsAddr, oldRunID, getStopOptions(d), timeout); err != nil {
+			adoptRun(runID)
+			orphanRun(oldRunID)
+			return fmt.Errorf("new run %v is running but previous run %v failed to stop, it was recorded in orphaned_run_ids: %v", runID, oldRunID, err)
+		}
+		d.Set("run_id", runID)
+		d.SetId(runID)
+		return setStreamingProgramRunAttributes(config, d)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, startAddr, bytes.NewReader(b))
+	if err := stopProgramRunAndWait(config, runsAddr, oldRunID, getStopOptions(d), timeout); err != nil {
+		keepOldRun()
+		return fmt.Errorf("failed to stop previous run %v: %v", oldRunID, err)
+	}
+	runID, err := startProgramAndWaitForRunning(config, d, args, timeout)
 	if err != nil {
-		return err
+		if runID == "" {
+			keepOldRun()
+			return fmt.Errorf("failed to start new run after previous run %v was stopped: %v", oldRunID, err)
+		}
+		adoptRun(runID)
+		return fmt.Errorf("new run %v did not reach RUNNING after previous run %v was stopped: %v", runID, oldRunID, err)
 	}
+	d.Set("run_id", runID)
+	d.SetId(runID)
+	return setStreamingProgramRunAttributes(config, d)
+}
 
-	if _, err := httpCall(config, req); err != nil {
-		return err
+// runPollInterval is how long to wait between polls of the status of a run.
+var runPollInterval = 10 * time.Second
+
+// startProgramAndWaitForRunning starts the program and polls until the new run is RUNNING.
+// The run id is returned if the run was found, even if it then failed to reach RUNNING.
+func startProgramAndWaitForRunning(config *Config, d resourceGetter, args map[string]string, timeout time.Duration) (string, error) {
+	started, err := startProgram(config, d, args)
+	if err != nil {
+		return "", err
 	}
+	return waitForRunning(config, urlJoin(getProgramAddr(config, d), "runs"), started, timeout)
+}
 
-	// Poll until actually reaches RUNNING state.
-	return resource.Retry(d.Timeout(schema.TimeoutCreate), func() *resource.RetryError {
-		time.Sleep(10 * time.Second) // avoid spamming retries and initial failure to find run.
-		r, err := getRunByFauxID(config, runsAddr, randomID.String())
-		if err != nil {
-			return resource.NonRetryableError(err)
+// waitForRunning polls until the started run is RUNNING. The run id is returned if the run was
+// found, even if it then failed to reach RUNNING.
+func waitForRunning(config *Config, runsAddr string, started *startedRun, timeout time.Duration) (string, error) {
+	var runID string
+	err := resource.Retry(timeout, func() *resource.RetryError {
+		time.Sleep(runPollInterval) // avoid spamming retries and initial failure to find run.
+		if runID == "" {
+			id, err := started.id(config, runsAddr)
+			if err != nil {
+				return resource.NonRetryableError(err)
+			}
+			runID = id
 		}
 
-		isRunning, err := isRunIDRunningYet(config, runsAddr, r.RunID)
+		isRunning, err := isRunIDRunningYet(config, runsAddr, runID)
 		if err != nil {
 			return resource.NonRetryableError(err)
 		}
 
 		if isRunning {
-			d.Set("run_id", r.RunID)
-			d.SetId(r.RunID)
 			return nil
 		}
-		return resource.RetryableError(fmt.Errorf("still waiting for program run with id: %v which is in an initializing state", r.RunID))
+		return resource.RetryableError(fmt.Errorf("still waiting for program run with id: %v which is in an initializing state", runID))
 	})
+	return runID, err
+}
+
+// Maps the program types used in the lifecycle URLs to the ones used by the batch APIs.
+var batchProgramTypes = map[string]string{
+	"flows":     "Flow",
+	"mapreduce": "MapReduce",
+	"services":  "Service",
+	"spark":     "Spark",
+	"workers":   "Worker",
+	"workflows": "Workflow",
+}
+
+type batchProgram struct {
+	AppID       string            `json:"appId"`
+	ProgramType string            `json:"programType"`
+	ProgramID   string            `json:"programId"`
+	RuntimeArgs map[string]string `json:"runtimeargs,omitempty"`
 }
 
-func resourceStreamingProgramRunRead(d *schema.ResourceData, m interface{}) error {
+type batchProgramResult struct {
+	AppID       string `json:"appId"`
+	ProgramType string `json:"programType"`
+	ProgramID   string `json:"programId"`
+	StatusCode  int    `json:"statusCode"`
+	Error       string `json:"error"`
+	// Status is only returned by the status API.
+	Status string `json:"status"`
+	// RunID is only returned by the start API of newer versions of CDAP.
+	RunID string `json:"runId"`
+}
+
+func (r *batchProgramResult) err() error {
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v: %v", r.StatusCode, r.Error)
+	}
 	return nil
 }
 
-type runtimeArgs struct {
-	FauxRunID string `json:"__FAUX_RUN_ID__"`
+func newBatchProgram(d resourceGetter, args map[string]string) *batchProgram {
+	return &batchProgram{
+		AppID:       d.Get("app").(string),
+		ProgramType: batchProgramTypes[d.Get("type").(string)],
+		ProgramID:   d.Get("program").(string),
+		RuntimeArgs: args,
+	}
 }
 
-type runtimeProperties struct {
-	RuntimeArgs *runtimeArgs `json:"runtimeArgs"`
+// callBatchProgramAPI calls one of the batch start, stop or status APIs, which return a result per program.
+// https://docs.cdap.io/cdap/current/en/reference-manual/http-restful-api/lifecycle.html
+func callBatchProgramAPI(config *Config, namespace, action string, programs []*batchProgram) ([]*batchProgramResult, error) {
+	b, err := json.Marshal(programs)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, urlJoin(config.host, "/v3/namespaces", namespace, action), bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	b, err = httpCall(config, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*batchProgramResult
+	if err := json.Unmarshal(b, &results); err != nil {
+		return nil, fmt.Errorf("could not unmarshal %v results: %v", action, err)
+	}
+	if len(results) != len(programs) {
+		return nil, fmt.Errorf("got %v %v results for %v programs", len(results), action, len(programs))
+	}
+	return results, nil
+}
+
+// startedRun identifies a run that was just started, either by the run id CDAP returned or by the
+// correlation argument added to its runtime arguments.
+type startedRun struct {
+	runID  string
+	fauxID string
+	since  time.Time
+}
+
+// id returns the id of the run, looking it up by the correlation argument if CDAP did not return it.
+func (s *startedRun) id(config *Config, runsAddr string) (string, error) {
+	if s.runID == "" {
+		// The run may already have failed, in which case its logs are reported.
+		r, err := getRunByFauxID(config, runsAddr, s.fauxID, runStatusAll, s.since)
+		if err != nil {
+			return "", err
+		}
+		s.runID = r.RunID
+	}
+	return s.runID, nil
+}
+
+// startProgram starts the program with the given runtime arguments.
+func startProgram(config *Config, d resourceGetter, args map[string]string) (*startedRun, error) {
+	started, errs, err := startPrograms(config, d.Get("namespace").(string), []*batchProgram{newBatchProgram(d, args)})
+	if err != nil {
+		return nil, err
+	}
+	if errs[0] != nil {
+		return nil, fmt.Errorf("failed to start program: %v", errs[0])
+	}
+	return started[0], nil
+}
+
+// startPrograms starts the programs with the batch start API and returns the started run or the
+// error of each program. The correlation argument is only added if the CDAP version does not
+// return run ids, as it is otherwise visible to the pipeline and in the UI.
+func startPrograms(config *Config, namespace string, programs []*batchProgram) ([]*startedRun, []error, error) {
+	correlate, err := needsRunCorrelation(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	started := make([]*startedRun, len(programs))
+	for i, p := range programs {
+		started[i] = &startedRun{since: time.Now()}
+		if !correlate {
+			continue
+		}
+		randomID, err := uuid.NewRandom()
+		if err != nil {
+			return nil, nil, fmt.Errorf("error generating uuid for faux run id: %v", err)
+		}
+		started[i].fauxID = randomID.String()
+		if p.RuntimeArgs == nil {
+			p.RuntimeArgs = make(map[string]string)
+		}
+		// This runtime arg will be unused by the pipeline but will allow the provider to associate a run with this resource.
+		p.RuntimeArgs[config.runCorrelationArg] = started[i].fauxID
+	}
+
+	results, err := callBatchProgramAPI(config, namespace, "/start", programs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	errs := make([]error, len(programs))
+	for i, res := range results {
+		switch {
+		case res.err() != nil:
+			errs[i] = res.err()
+			started[i] = nil
+		case res.RunID != "":
+			started[i].runID = res.RunID
+		case started[i].fauxID == "":
+			errs[i] = errors.New("CDAP did not return the id of the new run and run_correlation_argument is not set")
+			started[i] = nil
+		}
+	}
+	return started, errs, nil
+}
+
+// The first CDAP version whose batch start API returns the id of the new run.
+var minRunIDVersion = []int{6, 0, 0}
+
+// needsRunCorrelation returns whether the correlation argument must be added to find started runs,
+// which depends on the version of CDAP. The version is fetched once per provider configuration.
+func needsRunCorrelation(config *Config) (bool, error) {
+	if config.runCorrelationArg == "" {
+		return false, nil
+	}
+	config.versionOnce.Do(func() {
+		config.cdapVersion, config.versionErr = getCDAPVersion(config)
+	})
+	if config.versionErr != nil {
+		return false, fmt.Errorf("failed to get CDAP version: %v", config.versionErr)
+	}
+	v, err := parseCDAPVersion(config.cdapVersion)
+	if err != nil {
+		log.Printf("adding %v to runtime arguments: %v", config.runCorrelationArg, err)
+		return true, nil
+	}
+	for i := range minRunIDVersion {
+		if v[i] != minRunIDVersion[i] {
+			return v[i] < minRunIDVersion[i], nil
+		}
+	}
+	return false, nil
+}
+
+func getCDAPVersion(config *Config) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, urlJoin(config.host, "/v3/version"), nil)
+	if err != nil {
+		return "", err
+	}
+	b, err := httpCall(config, req)
+	if err != nil {
+		return "", err
+	}
+	var res struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(b, &res); err != nil {
+		return "", fmt.Errorf("could not unmarshal version: %v", err)
+	}
+	return res.Version, nil
+}
+
+// parseCDAPVersion returns the major, minor and patch version of a version such as 6.9.2 or
+// 6.10.0-SNAPSHOT.
+func parseCDAPVersion(s string) ([]int, error) {
+	parts := strings.SplitN(strings.SplitN(s, "-", 2)[0], ".", 4)
+	if len(parts) < 3 {
+		return nil, fmt.Errorf("unexpected CDAP version %q", s)
+	}
+	v := make([]int, 3)
+	for i := range v {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected CDAP version %q", s)
+		}
+		v[i] = n
+	}
+	return v, nil
 }
 
-func (ra *runtimeArgs) UnmarshalJSON(data []byte) error {
-	unquoted, err := strconv.Unquote(string(data))
+// resourceStreamingProgramRunRead records the status of the run. Runs that ended are either kept
+// in state to be restarted by the next apply, or removed so that they are started again, with a
+// warning explaining why in both cases.
+func resourceStreamingProgramRunRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*Config)
+
+	runsAddr := urlJoin(getProgramAddr(config, d), "runs")
+	if err := pruneOrphanedRuns(config, d, runsAddr); err != nil {
+		return diag.FromErr(err)
+	}
+	r, err := getRunByID(config, runsAddr, d.Id())
+	if isNotFound(err) {
+		id := d.Id()
+		d.SetId("")
+		return diag.Diagnostics{{
+			Severity: diag.Warning,
+			Summary:  fmt.Sprintf("Program run %v no longer exists and will be started again", id),
+		}}
+	}
 	if err != nil {
-		return fmt.Errorf("failed to escape runtime arguments %v: %v", string(data), err)
+		return diag.FromErr(err)
+	}
+	setRunStatusAttributes(d, r)
+
+	if !programRunEndStatuses[r.Status] {
+		d.Set("termination_reason", "")
+		return nil
+	}
+
+	reason := fmt.Sprintf("run ended in state %v", r.Status)
+	if programRunUnsuccessfulStatuses[r.Status] {
+		if lines, err := getRunLogLines(config, runsAddr, r.RunID, 1); err != nil {
+			log.Printf("failed to fetch logs of run %v: %v", r.RunID, err)
+		} else if len(lines) > 0 {
+			reason = lines[0]
+		}
+	}
+	d.Set("termination_reason", reason)
+
+	summary := fmt.Sprintf("Program run %v ended in state %v at %v", r.RunID, r.Status, formatRunTime(r.End))
+	if d.Get("restart_on_failure").(bool) {
+		return diag.Diagnostics{{Severity: diag.Warning, Summary: summary + ", it will be restarted", Detail: reason}}
+	}
+	d.SetId("")
+	return diag.Diagnostics{{Severity: diag.Warning, Summary: summary + ", it will be started again", Detail: reason}}
+}
+
+// pruneOrphanedRuns drops the orphaned runs that ended or no longer exist.
+func pruneOrphanedRuns(config *Config, d *schema.ResourceData, runsAddr string) error {
+	var active []interface{}
+	for _, id := range d.Get("orphaned_run_ids").([]interface{}) {
+		r, err := getRunByID(config, runsAddr, id.(string))
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !programRunEndStatuses[r.Status] {
+			active = append(active, id)
+		}
 	}
+	return d.Set("orphaned_run_ids", active)
+}
 
-	// Use alias to avoid infinite recursion: https://stackoverflow.com/q/52433467
-	type alias runtimeArgs
-	var a alias
-	if err := json.Unmarshal([]byte(unquoted), &a); err != nil {
+// resourceStreamingProgramRunCustomizeDiff plans the restart of a run that ended if restart_on_failure is set.
+func resourceStreamingProgramRunCustomizeDiff(_ context.Context, d *schema.ResourceDiff, m interface{}) error {
+	if d.Id() == "" || !d.Get("restart_on_failure").(bool) || !programRunEndStatuses[d.Get("status").(string)] {
+		return nil
+	}
+	for _, k := range []string{"run_id", "start_time", "end_time", "termination_reason"} {
+		if err := d.SetNewComputed(k); err != nil {
+			return err
+		}
+	}
+	return d.SetNew("status", "RUNNING")
+}
+
+func setStreamingProgramRunAttributes(config *Config, d *schema.ResourceData) error {
+	r, err := getRunByID(config, urlJoin(getProgramAddr(config, d), "runs"), d.Id())
+	if err != nil {
 		return err
 	}
-	*ra = runtimeArgs(a)
+	setRunStatusAttributes(d, r)
+	d.Set("termination_reason", "")
 	return nil
 }
 
+func setRunStatusAttributes(d *schema.ResourceData, r *run) {
+	d.Set("status", r.Status)
+	d.Set("start_time", formatRunTime(r.Start))
+	d.Set("end_time", formatRunTime(r.End))
+}
+
+type runtimeProperties struct {
+	// RuntimeArgs is a JSON string holding the encoded runtime arguments. It is only decoded when
+	// needed, as runs can have many large arguments.
+	RuntimeArgs json.RawMessage `json:"runtimeArgs"`
+}
+
+func (p *runtimeProperties) runtimeArgs() (map[string]string, error) {
+	if len(p.RuntimeArgs) == 0 {
+		return nil, nil
+	}
+	var encoded string
+	if err := json.Unmarshal(p.RuntimeArgs, &encoded); err != nil {
+		return nil, fmt.Errorf("failed to unescape runtime arguments %v: %v", string(p.RuntimeArgs), err)
+	}
+	args := make(map[string]string)
+	if err := json.Unmarshal([]byte(encoded), &args); err != nil {
+		return nil, fmt.Errorf("could not unmarshal runtime arguments: %v", err)
+	}
+	return args, nil
+}
+
 // These are the only keys we need
 type run struct {
 	RunID      string            `json:"runid"`
 	Status     string            `json:"status"`
+	Starting   int64             `json:"starting"`
+	Start      int64             `json:"start"`
+	Stopping   int64             `json:"stopping"`
+	End        int64             `json:"end"`
 	Properties runtimeProperties `json:"properties"`
+	Cluster    *runCluster       `json:"cluster"`
+	Profile    *runProfile       `json:"profile"`
+}
+
+// startingTime returns when the run was requested, which the start and end filters of the runs API
+// use. Unlike start, it is also set for PENDING and STARTING runs.
+func (r *run) startingTime() int64 {
+	if r.Starting > 0 {
+		return r.Starting
+	}
+	return r.Start
+}
+
+type runCluster struct {
+	Status   string `json:"status"`
+	NumNodes int    `json:"numNodes"`
+}
+
+type runProfile struct {
+	Namespace string `json:"namespace"`
+	Profile   string `json:"profile"`
 }
 
 // Checks if there is a running run for the terraform faux run id
@@ -208,13 +677,41 @@ func isRunIDRunningYet(config *Config, runsAddr string, runID string) (bool, err
 		return true, nil
 	}
 	if !programRunInitializingStatuses[r.Status] {
-		return false, fmt.Errorf("program not running or initializing, in state: %v", r.Status)
+		err := fmt.Errorf("program not running or initializing, in state: %v", r.Status)
+		if programRunUnsuccessfulStatuses[r.Status] {
+			err = withRunLogs(config, runsAddr, runID, err)
+		}
+		return false, err
 	}
 	return false, nil
 }
 
-func getRunByFauxID(config *Config, runsAddr string, fauxRunID string) (*run, error) {
-	req, err := http.NewRequest(http.MethodGet, runsAddr, nil)
+// Matches the level in CDAP's default log pattern, e.g. "2020-01-01 00:00:00,000 - ERROR [main:c.Foo@1] - msg".
+var runLogLevelRE = regexp.MustCompile(` - (ERROR|WARN) `)
+
+// withRunLogs appends the last ERROR and WARN log lines of the run to err, so that failures can be
+// diagnosed without opening the UI.
+func withRunLogs(config *Config, runsAddr, runID string, err error) error {
+	if config.failureLogLines <= 0 {
+		return err
+	}
+	lines, logErr := getRunLogLines(config, runsAddr, runID, config.failureLogLines)
+	if logErr != nil {
+		log.Printf("failed to fetch logs of run %v: %v", runID, logErr)
+		return err
+	}
+	if len(lines) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w\nlast %d ERROR/WARN log lines of run %v:\n%s", err, len(lines), runID, strings.Join(lines, "\n"))
+}
+
+// getRunLogLines returns up to n of the last ERROR and WARN log lines of the run.
+func getRunLogLines(config *Config, runsAddr, runID string, n int) ([]string, error) {
+	q := url.Values{}
+	q.Set("escape", "false")
+	q.Set("filter", "loglevel=WARN")
+	req, err := http.NewRequest(http.MethodGet, urlJoin(runsAddr, runID, "/logs")+"?"+q.Encode(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -224,20 +721,118 @@ func getRunByFauxID(config *Config, runsAddr string, fauxRunID string) (*run, er
 		return nil, err
 	}
 
-	var runs []*run
+	// Skip the stack trace lines that follow a log message.
+	var lines []string
+	for _, l := range strings.Split(string(b), "\n") {
+		if runLogLevelRE.MatchString(l) {
+			lines = append(lines, strings.TrimSpace(l))
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+// Runs are looked up from a while before the program was started, in case the clocks of CDAP and
+// the provider differ.
+const runLookupClockSkew = 5 * time.Minute
+
+// The number of runs to fetch per request when looking up a run.
+const runsPageSize = 100
+
+// The status filter of the runs API that matches runs in any status.
+const runStatusAll = "all"
+
+// runFilter holds the query parameters of the runs API. Zero values are not sent.
+type runFilter struct {
+	status string
+	// start and end bound the start time of the runs, in seconds since epoch.
+	start, end int64
+	limit      int
+}
 
+func listRuns(config *Config, runsAddr string, f *runFilter) ([]*run, error) {
+	q := url.Values{}
+	if f.status != "" {
+		q.Set("status", f.status)
+	}
+	if f.start > 0 {
+		q.Set("start", strconv.FormatInt(f.start, 10))
+	}
+	if f.end > 0 {
+		q.Set("end", strconv.FormatInt(f.end, 10))
+	}
+	if f.limit > 0 {
+		q.Set("limit", strconv.Itoa(f.limit))
+	}
+	addr := runsAddr
+	if len(q) > 0 {
+		addr += "?" + q.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	b, err := httpCall(config, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []*run
 	if err = json.Unmarshal(b, &runs); err != nil {
 		return nil, fmt.Errorf("could not unmarshal run payload: %v", err)
 	}
+	return runs, nil
+}
+
+// getRunByFauxID finds the run in the given status started at or after since with the given faux
+// run id. The runs API returns at most limit runs, so full pages are followed by a request for the
+// runs that started before the oldest run of the page. Runs are de-duplicated by id, so the order
+// of the runs in a page does not matter.
+func getRunByFauxID(config *Config, runsAddr, fauxID, status string, since time.Time) (*run, error) {
+	f := &runFilter{status: status, start: since.Add(-runLookupClockSkew).Unix(), limit: runsPageSize}
+	seen := make(map[string]bool)
+	for {
+		runs, err := listRuns(config, runsAddr, f)
+		if err != nil {
+			return nil, err
+		}
+
+		added := false
+		oldest := int64(math.MaxInt64)
+		for _, r := range runs {
+			if t := r.startingTime(); t > 0 && t < oldest {
+				oldest = t
+			}
+			if seen[r.RunID] {
+				continue
+			}
+			seen[r.RunID] = true
+			added = true
 
-	for _, r := range runs {
-		args := r.Properties.RuntimeArgs
-		log.Printf("found terraform run id: %v faux run id: %v status: %v", r.RunID, args.FauxRunID, r.Status)
-		if fauxRunID == args.FauxRunID {
-			return r, nil
+			// Avoid decoding the arguments of every run.
+			if !bytes.Contains(r.Properties.RuntimeArgs, []byte(fauxID)) {
+				continue
+			}
+			args, err := r.Properties.runtimeArgs()
+			if err != nil {
+				return nil, err
+			}
+			if args[config.runCorrelationArg] == fauxID {
+				log.Printf("found terraform run id: %v faux run id: %v status: %v", r.RunID, fauxID, r.Status)
+				return r, nil
+			}
 		}
+
+		if len(runs) < f.limit || !added || oldest == math.MaxInt64 {
+			break
+		}
+		// The end bound may be exclusive, so include the oldest start time again.
+		f.end = oldest + 1
 	}
-	return nil, fmt.Errorf("no run found with faux runid: %v", fauxRunID)
+	return nil, fmt.Errorf("no run found with faux runid: %v", fauxID)
 }
 
 func getRunByID(config *Config, runsAddr string, runID string) (*run, error) {
@@ -248,7 +843,7 @@ func getRunByID(config *Config, runsAddr string, runID string) (*run, error) {
 
 	b, err := httpCall(config, req)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't retrived run with run id: %v: %s", runID, err)
+		return nil, fmt.Errorf("couldn't retrived run with run id: %v: %w", runID, err)
 	}
 
 	var r *run
@@ -260,7 +855,12 @@ func getRunByID(config *Config, runsAddr string, runID string) (*run, error) {
 	return r, nil
 }
 
-func stopProgramRun(config *Config, stopAddr string) error {
+// stopProgramRun requests the run to stop, waiting up to gracefulShutdownSecs for it to drain.
+// A negative value leaves the drain window to CDAP, and zero terminates the run immediately.
+func stopProgramRun(config *Config, stopAddr string, gracefulShutdownSecs int) error {
+	if gracefulShutdownSecs >= 0 {
+		stopAddr += "?gracefulShutdownSecs=" + strconv.Itoa(gracefulShutdownSecs)
+	}
 	req, err := http.NewRequest(http.MethodPost, stopAddr, nil)
 	if err != nil {
 		return err
@@ -274,73 +874,97 @@ func resourceStreamingProgramRunDelete(d *schema.ResourceData, m interface{}) er
 
 	addr := getProgramAddr(config, d)
 	runsAddr := urlJoin(addr, "/runs")
-	stopAddr := urlJoin(runsAddr, d.Id(), "/stop")
-
-	return resource.Retry(d.Timeout(schema.TimeoutDelete), func() *resource.RetryError {
-		r, err := getRunByID(config, runsAddr, d.Id())
-		if err != nil {
-			return resource.NonRetryableError(fmt.Errorf("error getting program status by faux id: %v", err))
-		}
-
-		if r.Status == "RUNNING" || programRunInitializingStatuses[r.Status] {
-			err = stopProgramRun(config, stopAddr)
-			if err != nil {
-				return resource.NonRetryableError(fmt.Errorf("error stopping program: %v", err))
-			}
-			time.Sleep(10 * time.Second)
-			return resource.RetryableError(errors.New("Polling again to see if status progressed from RUNNING to an end status"))
-		}
 
-		if programRunEndStatuses[r.Status] {
-			return nil
+	for _, id := range d.Get("orphaned_run_ids").([]interface{}) {
+		if err := stopProgramRunAndWait(config, runsAddr, id.(string), getStopOptions(d), d.Timeout(schema.TimeoutDelete)); err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to stop orphaned run %v: %v", id, err)
 		}
-
-		return resource.NonRetryableError(fmt.Errorf("failed to delete run with id: %v and faux id: %v in status: %v", r.RunID, d.Id(), r.Status))
-	})
+	}
+	return stopProgramRunAndWait(config, runsAddr, d.Id(), getStopOptions(d), d.Timeout(schema.TimeoutDelete))
 }
 
-func resourceStreamingProgramRunExists(d *schema.ResourceData, m interface{}) (bool, error) {
-	config := m.(*Config)
-
-	addr := getProgramAddr(config, d)
-	statusAddr := urlJoin(addr, "/status")
-	runAddr := urlJoin(addr, "/runs")
-
-	req, err := http.NewRequest(http.MethodGet, statusAddr, nil)
-	if err != nil {
-		return false, err
+// stopOptionsSchema returns the attributes controlling how runs of a program are stopped.
+func stopOptionsSchema() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		"graceful_shutdown_seconds": {
+			Type:         schema.TypeInt,
+			Optional:     true,
+			Description:  "How long a run is given to drain in-flight work when it is stopped. If not provided, the CDAP default is used.",
+			ValidateFunc: validation.IntAtLeast(1),
+		},
+		"force_kill": {
+			Type:         schema.TypeBool,
+			Optional:     true,
+			Default:      false,
+			RequiredWith: []string{"graceful_shutdown_seconds"},
+			Description:  "Whether to terminate the run once graceful_shutdown_seconds have passed and it has still not stopped. Requires graceful_shutdown_seconds.",
+		},
 	}
+}
 
-	b, err := httpCall(config, req)
-	if err != nil {
-		return false, err
-	}
+type stopOptions struct {
+	// gracefulShutdownSecs is negative if CDAP decides the drain window.
+	gracefulShutdownSecs int
+	forceKill            bool
+}
 
-	var p programStatus
-	if err := json.Unmarshal(b, &p); err != nil {
-		return false, err
+func getStopOptions(d resourceGetter) *stopOptions {
+	opts := &stopOptions{gracefulShutdownSecs: -1}
+	// Runs are only killed after a graceful window, which force_kill requires.
+	if secs := d.Get("graceful_shutdown_seconds").(int); secs > 0 {
+		opts.gracefulShutdownSecs = secs
+		opts.forceKill = d.Get("force_kill").(bool)
 	}
+	return opts
+}
+
+// stopProgramRunAndWait stops the run if it is still active and waits for it to reach an end status.
+// If opts.forceKill is set, the run is terminated once the graceful shutdown window has passed.
+func stopProgramRunAndWait(config *Config, runsAddr, runID string, opts *stopOptions, timeout time.Duration) error {
+	stopAddr := urlJoin(runsAddr, runID, "/stop")
+	killAt := time.Now().Add(time.Duration(opts.gracefulShutdownSecs) * time.Second)
+	killed := false
 
-	running := false
-	// This checks if the program is running (but it may be running several times)
-	if p.Status == "RUNNING" {
-		// This handles ambiguity if there are multiple program runs
-		running, err = isRunIDRunningYet(config, runAddr, d.Id())
+	return resource.Retry(timeout, func() *resource.RetryError {
+		r, err := getRunByID(config, runsAddr, runID)
 		if err != nil {
-			return false, fmt.Errorf("error determining status of run with FauxId %v", d.Id())
+			return resource.NonRetryableError(fmt.Errorf("error getting program status by faux id: %w", err))
 		}
 
-		return running, nil
-	}
+		switch {
+		case programRunEndStatuses[r.Status]:
+			return nil
+		case opts.forceKill && !killed && !time.Now().Before(killAt):
+			log.Printf("run %v did not stop in time, terminating it", runID)
+			if err := stopProgramRun(config, stopAddr, 0); err != nil {
+				return resource.NonRetryableError(fmt.Errorf("error terminating program: %v", err))
+			}
+			killed = true
+		case r.Status == "RUNNING" || programRunInitializingStatuses[r.Status]:
+			if err := stopProgramRun(config, stopAddr, opts.gracefulShutdownSecs); err != nil {
+				return resource.NonRetryableError(fmt.Errorf("error stopping program: %v", err))
+			}
+		case r.Status == "STOPPING":
+			// The run is draining, so wait for it without resetting the graceful shutdown window.
+		default:
+			return resource.NonRetryableError(fmt.Errorf("failed to delete run with id: %v and faux id: %v in status: %v", r.RunID, runID, r.Status))
+		}
 
-	return false, nil
+		time.Sleep(runPollInterval)
+		return resource.RetryableError(fmt.Errorf("still waiting for run %v in state %v to reach an end status", runID, r.Status))
+	})
 }
 
 type programStatus struct {
 	Status string `json:"status"`
 }
 
-func getProgramAddr(config *Config, d *schema.ResourceData) string {
+// resourceGetter is implemented by both schema.ResourceData and schema.ResourceDiff.
+type resourceGetter interface {
+	Get(key string) interface{}
+}
+
+func getProgramAddr(config *Config, d resourceGetter) string {
 	return urlJoin(
 		config.host,
 		"/v3/namespaces", d.Get("namespace").(string),
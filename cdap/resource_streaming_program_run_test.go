@@ -0,0 +1,140 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdap
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// fakeProgramServer serves the version, batch start, run and stop APIs of a single program whose
+// runs move to RUNNING when started and to KILLED when stopped.
+type fakeProgramServer struct {
+	mu     sync.Mutex
+	runs   map[string]string
+	nextID string
+	// failStop lists the runs whose stop requests fail.
+	failStop map[string]bool
+}
+
+func (f *fakeProgramServer) start(t *testing.T) *Config {
+	t.Helper()
+	const runsPath = "/v3/namespaces/default/apps/app/spark/prog/runs/"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		switch {
+		case r.URL.Path == "/v3/version":
+			json.NewEncoder(w).Encode(map[string]string{"version": "6.9.0"})
+		case r.URL.Path == "/v3/namespaces/default/start":
+			f.runs[f.nextID] = "RUNNING"
+			json.NewEncoder(w).Encode([]*batchProgramResult{{StatusCode: http.StatusOK, RunID: f.nextID}})
+		case strings.HasPrefix(r.URL.Path, runsPath) && strings.HasSuffix(r.URL.Path, "/stop"):
+			id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, runsPath), "/stop")
+			if f.failStop[id] {
+				http.Error(w, "cannot stop", http.StatusInternalServerError)
+				return
+			}
+			f.runs[id] = "KILLED"
+		case strings.HasPrefix(r.URL.Path, runsPath):
+			id := strings.TrimPrefix(r.URL.Path, runsPath)
+			status, ok := f.runs[id]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(&run{RunID: id, Status: status, Start: 1})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return &Config{host: srv.URL, httpClient: srv.Client(), runCorrelationArg: defaultRunCorrelationArgument}
+}
+
+func restartStreamingProgramRun(t *testing.T, config *Config, strategy string) (*schema.ResourceData, error) {
+	t.Helper()
+	interval := runPollInterval
+	runPollInterval = time.Millisecond
+	t.Cleanup(func() { runPollInterval = interval })
+
+	d := schema.TestResourceDataRaw(t, resourceStreamingProgramRun().Schema, map[string]interface{}{
+		"app":               "app",
+		"program":           "prog",
+		"type":              "spark",
+		"runtime_arguments": map[string]interface{}{"k": "new"},
+		"restart_strategy":  strategy,
+	})
+	d.SetId("old")
+	return d, resourceStreamingProgramRunUpdate(d, config)
+}
+
+func TestStreamingProgramRunUpdateRecordsNewRun(t *testing.T) {
+	for _, strategy := range []string{"start_then_stop", "stop_then_start"} {
+		t.Run(strategy, func(t *testing.T) {
+			f := &fakeProgramServer{runs: map[string]string{"old": "RUNNING"}, nextID: "new"}
+			config := f.start(t)
+
+			d, err := restartStreamingProgramRun(t, config, strategy)
+			if err != nil {
+				t.Fatalf("resourceStreamingProgramRunUpdate() failed: %v", err)
+			}
+			if d.Id() != "new" || d.Get("run_id").(string) != "new" {
+				t.Errorf("got id %q and run_id %q, want the new run", d.Id(), d.Get("run_id"))
+			}
+			if got := d.Get("status").(string); got != "RUNNING" {
+				t.Errorf("got status %q, want RUNNING", got)
+			}
+			if got := f.runs["old"]; got != "KILLED" {
+				t.Errorf("got old run status %q, want KILLED", got)
+			}
+		})
+	}
+}
+
+func TestStreamingProgramRunUpdateOrphansOldRun(t *testing.T) {
+	f := &fakeProgramServer{runs: map[string]string{"old": "RUNNING"}, nextID: "new", failStop: map[string]bool{"old": true}}
+	config := f.start(t)
+
+	d, err := restartStreamingProgramRun(t, config, "start_then_stop")
+	if err == nil {
+		t.Fatal("got no error when the previous run failed to stop")
+	}
+	if d.Id() != "new" {
+		t.Errorf("got id %q, want the new run", d.Id())
+	}
+	if got := d.Get("orphaned_run_ids").([]interface{}); len(got) != 1 || got[0] != "old" {
+		t.Errorf("got orphaned_run_ids %v, want [old]", got)
+	}
+}
+
+func TestValidateStreamingProgramType(t *testing.T) {
+	if warnings, errs := validateStreamingProgramType("spark", "type"); len(warnings) != 0 || len(errs) != 0 {
+		t.Errorf("got warnings %v and errors %v for spark, want none", warnings, errs)
+	}
+	if warnings, errs := validateStreamingProgramType("flows", "type"); len(warnings) != 1 || len(errs) != 0 {
+		t.Errorf("got warnings %v and errors %v for flows, want a deprecation warning", warnings, errs)
+	}
+	if _, errs := validateStreamingProgramType("flowlets", "type"); len(errs) == 0 {
+		t.Error("got no error for flowlets")
+	}
+}